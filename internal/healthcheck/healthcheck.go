@@ -0,0 +1,195 @@
+// Package healthcheck реализует общий для всех сервисов cinemaabyss механизм
+// FT-style /__health, /__gtg и /__build-info: фоновый прогон проверок зависимостей
+// по тикеру с кэшированным снимком результатов, чтобы сами эндпоинты отвечали быстро
+// и не дергали зависимости синхронно на каждый запрос.
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Severity зависимостей по конвенции FT: 1 — критична и блокирует /__gtg, 2 — деградация без простоя
+const (
+	SeverityCritical = 1
+	SeverityWarning  = 2
+)
+
+// CheckResult — закэшированный результат последнего прогона проверки
+type CheckResult struct {
+	Name             string    `json:"name"`
+	OK               bool      `json:"ok"`
+	Severity         int       `json:"severity"`
+	TechnicalSummary string    `json:"technicalSummary"`
+	BusinessImpact   string    `json:"businessImpact"`
+	CheckOutput      string    `json:"checkOutput"`
+	LastUpdated      time.Time `json:"lastUpdated"`
+}
+
+// Check описывает одну фоновую проверку зависимости сервиса
+type Check struct {
+	Name           string
+	Severity       int
+	BusinessImpact string
+	Interval       time.Duration
+	Run            func(ctx context.Context) (checkOutput string, err error)
+}
+
+// Registry прогоняет проверки по тикеру в фоне и отдает закэшированный снимок,
+// чтобы /__gtg отвечал быстро и не дергал зависимости синхронно на каждый запрос
+type Registry struct {
+	mu      sync.RWMutex
+	checks  []*Check
+	results map[string]CheckResult
+	stopCh  chan struct{}
+}
+
+// NewRegistry регистрирует проверки и запускает их фоновые циклы
+func NewRegistry(checks []*Check) *Registry {
+	registry := &Registry{
+		checks:  checks,
+		results: make(map[string]CheckResult, len(checks)),
+		stopCh:  make(chan struct{}),
+	}
+
+	for _, check := range checks {
+		registry.results[check.Name] = CheckResult{
+			Name:             check.Name,
+			Severity:         check.Severity,
+			BusinessImpact:   check.BusinessImpact,
+			TechnicalSummary: "еще не проверено",
+		}
+		go registry.loop(check)
+	}
+
+	return registry
+}
+
+func (registry *Registry) loop(check *Check) {
+	registry.runOnce(check)
+
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-registry.stopCh:
+			return
+		case <-ticker.C:
+			registry.runOnce(check)
+		}
+	}
+}
+
+func (registry *Registry) runOnce(check *Check) {
+	ctx, cancel := context.WithTimeout(context.Background(), check.Interval)
+	defer cancel()
+
+	output, err := check.Run(ctx)
+
+	result := CheckResult{
+		Name:           check.Name,
+		OK:             err == nil,
+		Severity:       check.Severity,
+		BusinessImpact: check.BusinessImpact,
+		CheckOutput:    output,
+		LastUpdated:    time.Now().UTC(),
+	}
+	if err != nil {
+		result.TechnicalSummary = err.Error()
+	} else {
+		result.TechnicalSummary = "ok"
+	}
+
+	registry.mu.Lock()
+	registry.results[check.Name] = result
+	registry.mu.Unlock()
+}
+
+// Snapshot возвращает закэшированные результаты всех проверок в порядке регистрации
+func (registry *Registry) Snapshot() []CheckResult {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	results := make([]CheckResult, 0, len(registry.checks))
+	for _, check := range registry.checks {
+		results = append(results, registry.results[check.Name])
+	}
+	return results
+}
+
+// Stop останавливает фоновые циклы всех проверок
+func (registry *Registry) Stop() {
+	close(registry.stopCh)
+}
+
+// HealthHandler отдает /__health: агрегированный статус и результаты всех проверок.
+// serviceName попадает в поле "name" ответа; extra, если не nil, вызывается на каждый
+// запрос и домешивает в ответ дополнительные поля, специфичные для конкретного сервиса
+// (например, лаг потребителя событий).
+func (registry *Registry) HealthHandler(serviceName string, extra func() map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := registry.Snapshot()
+		ok := true
+		for _, check := range checks {
+			if check.Severity == SeverityCritical && !check.OK {
+				ok = false
+			}
+		}
+
+		body := map[string]interface{}{
+			"schemaVersion": 1,
+			"name":          serviceName,
+			"ok":            ok,
+			"checks":        checks,
+		}
+		if extra != nil {
+			for key, value := range extra() {
+				body[key] = value
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// GTGHandler отдает /__gtg: 200 только если все критичные (severity=1) проверки прошли, иначе 503
+func (registry *Registry) GTGHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range registry.Snapshot() {
+			if check.Severity == SeverityCritical && !check.OK {
+				http.Error(w, "NOT OK: "+check.Name, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("OK"))
+	}
+}
+
+// BuildInfoHandler отдает /__build-info: версия и коммит сборки из переменных окружения
+func BuildInfoHandler(serviceName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"name":    serviceName,
+			"version": envOrDefault("BUILD_VERSION", "dev"),
+			"commit":  envOrDefault("BUILD_COMMIT", "unknown"),
+		})
+	}
+}
+
+// envOrDefault возвращает значение переменной окружения или значение по умолчанию,
+// если она не задана или пуста
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
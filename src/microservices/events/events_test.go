@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestHasScopeMatchesSpaceSeparatedScopeClaim(t *testing.T) {
+	claims := jwt.MapClaims{"scope": "events:movie:write events:user:write"}
+
+	if !hasScope(claims, "events:movie:write") {
+		t.Fatalf("expected scope 'events:movie:write' to be found in 'scope' claim")
+	}
+	if hasScope(claims, "events:payment:write") {
+		t.Fatalf("did not expect scope 'events:payment:write' to be found")
+	}
+}
+
+func TestHasScopeMatchesScpArrayClaim(t *testing.T) {
+	claims := jwt.MapClaims{"scp": []interface{}{"events:payment:write", "events:user:write"}}
+
+	if !hasScope(claims, "events:payment:write") {
+		t.Fatalf("expected scope 'events:payment:write' to be found in 'scp' claim")
+	}
+	if hasScope(claims, "events:movie:write") {
+		t.Fatalf("did not expect scope 'events:movie:write' to be found")
+	}
+}
+
+func TestHasScopeMissingClaimsReturnsFalse(t *testing.T) {
+	if hasScope(jwt.MapClaims{}, "events:movie:write") {
+		t.Fatalf("expected no scope to match when claims carry neither 'scope' nor 'scp'")
+	}
+}
+
+func TestTopicRouterRoutesByMatchingLabel(t *testing.T) {
+	router, err := NewTopicRouter([]TopicRouterRule{
+		{Label: "__name__", Pattern: "^http_.*", Topic: "http-metrics"},
+		{Label: "__name__", Pattern: "^db_.*", Topic: "db-metrics"},
+	}, defaultMetricsTopic)
+	if err != nil {
+		t.Fatalf("NewTopicRouter() error = %v", err)
+	}
+
+	topic := router.Route([]prompb.Label{{Name: "__name__", Value: "http_requests_total"}})
+	if topic != "http-metrics" {
+		t.Fatalf("Route() = %q, want %q", topic, "http-metrics")
+	}
+}
+
+func TestTopicRouterFallsBackToDefaultTopic(t *testing.T) {
+	router, err := NewTopicRouter([]TopicRouterRule{
+		{Label: "__name__", Pattern: "^http_.*", Topic: "http-metrics"},
+	}, defaultMetricsTopic)
+	if err != nil {
+		t.Fatalf("NewTopicRouter() error = %v", err)
+	}
+
+	topic := router.Route([]prompb.Label{{Name: "__name__", Value: "unrelated_metric"}})
+	if topic != defaultMetricsTopic {
+		t.Fatalf("Route() = %q, want default topic %q", topic, defaultMetricsTopic)
+	}
+}
+
+func TestTopicRouterTopicsIncludesDefaultAndRuleTopicsWithoutDuplicates(t *testing.T) {
+	router, err := NewTopicRouter([]TopicRouterRule{
+		{Label: "__name__", Pattern: "^http_.*", Topic: "http-metrics"},
+		{Label: "__name__", Pattern: "^db_.*", Topic: "db-metrics"},
+		{Label: "__name__", Pattern: "^db2_.*", Topic: "db-metrics"},
+	}, defaultMetricsTopic)
+	if err != nil {
+		t.Fatalf("NewTopicRouter() error = %v", err)
+	}
+
+	got := router.Topics()
+	want := []string{defaultMetricsTopic, "http-metrics", "db-metrics"}
+	if len(got) != len(want) {
+		t.Fatalf("Topics() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Topics() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTopicRouterRejectsInvalidPattern(t *testing.T) {
+	if _, err := NewTopicRouter([]TopicRouterRule{
+		{Label: "__name__", Pattern: "(", Topic: "broken"},
+	}, defaultMetricsTopic); err == nil {
+		t.Fatalf("expected an error for an invalid regex pattern")
+	}
+}
+
+// DLQ-писатель обязан быть синхронным и без Completion: иначе сбой доставки в
+// "<topic>.dlq" снова вызовет publishToDLQ и при устойчивой недоступности Kafka
+// породит бесконечную цепочку "<topic>.dlq.dlq.dlq...".
+func TestSyncTopicWriterUsedForDLQHasNoCompletionCallback(t *testing.T) {
+	cfg := loadWriterConfig()
+	cfg.Async = false
+	writer := initKafkaWriter("movie-events"+dlqSuffix, cfg)
+	defer writer.Close()
+
+	if writer.Completion != nil {
+		t.Fatalf("expected a synchronous DLQ writer to have no Completion callback, got one: a failed write would re-enter publishToDLQ and cascade forever")
+	}
+	if writer.Async {
+		t.Fatalf("expected the DLQ writer built for publishToDLQ to be synchronous")
+	}
+}
@@ -1,16 +1,53 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	_ "github.com/lib/pq"
+	goavro "github.com/linkedin/goavro/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	protobuflib "github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+
+	"github.com/AlexanderMATI/architecture-pro-cinemaabyss/internal/healthcheck"
 )
 
 // Структуры событий с добавленными тегами для валидации
@@ -38,73 +75,1359 @@ type PaymentEvent struct {
 
 // Константы для названий топиков
 const (
-	movieTopic   = "movie-events"
-	userTopic    = "user-events"
-	paymentTopic = "payment-events"
+	movieTopic          = "movie-events"
+	userTopic           = "user-events"
+	paymentTopic        = "payment-events"
+	defaultMetricsTopic = "metrics-events"
+)
+
+var (
+	metricsRouter     *TopicRouter
+	metricsRouterOnce sync.Once
+
+	topicWriters   = map[string]*kafka.Writer{}
+	topicWritersMu sync.Mutex
+)
+
+// dlqSuffix добавляется к названию топика для получения его dead-letter топика
+const dlqSuffix = ".dlq"
+
+// WriterConfig описывает настройки надежности Kafka writer'а
+type WriterConfig struct {
+	RequiredAcks    kafka.RequiredAcks
+	Async           bool
+	BatchTimeout    time.Duration
+	MaxAttempts     int
+	WriteBackoffMin time.Duration
+	WriteBackoffMax time.Duration
+}
+
+// loadWriterConfig загружает настройки writer'а из переменных окружения
+func loadWriterConfig() WriterConfig {
+	return WriterConfig{
+		RequiredAcks:    kafka.RequireAll,
+		Async:           getEnv("KAFKA_WRITER_ASYNC", "true") == "true",
+		BatchTimeout:    getEnvDuration("KAFKA_WRITER_BATCH_TIMEOUT", 10*time.Millisecond),
+		MaxAttempts:     getEnvInt("KAFKA_WRITER_MAX_ATTEMPTS", 5),
+		WriteBackoffMin: getEnvDuration("KAFKA_WRITER_BACKOFF_MIN", 100*time.Millisecond),
+		WriteBackoffMax: getEnvDuration("KAFKA_WRITER_BACKOFF_MAX", 1*time.Second),
+	}
+}
+
+// getEnvInt возвращает целочисленное значение переменной окружения или значение по умолчанию
+func getEnvInt(key string, fallback int) int {
+	raw := getEnv(key, "")
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Некорректное значение %s '%s', используется %d", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration возвращает значение переменной окружения как time.Duration или значение по умолчанию
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	raw := getEnv(key, "")
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Некорректное значение %s '%s', используется %s", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// TopicRouterRule описывает одно правило маршрутизации метрики в топик Kafka
+type TopicRouterRule struct {
+	Label   string `json:"label"`
+	Pattern string `json:"pattern"`
+	Topic   string `json:"topic"`
+}
+
+// topicRouterRule скомпилированное правило маршрутизации
+type topicRouterRule struct {
+	label   string
+	pattern *regexp.Regexp
+	topic   string
+}
+
+// TopicRouter выбирает топик Kafka для набора меток Prometheus TimeSeries
+type TopicRouter struct {
+	rules        []topicRouterRule
+	defaultTopic string
+}
+
+// NewTopicRouter создает TopicRouter из списка правил
+func NewTopicRouter(rules []TopicRouterRule, defaultTopic string) (*TopicRouter, error) {
+	router := &TopicRouter{defaultTopic: defaultTopic}
+
+	for _, rule := range rules {
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный паттерн '%s' для метки '%s': %w", rule.Pattern, rule.Label, err)
+		}
+		router.rules = append(router.rules, topicRouterRule{
+			label:   rule.Label,
+			pattern: compiled,
+			topic:   rule.Topic,
+		})
+	}
+
+	return router, nil
+}
+
+// Route определяет топик Kafka для набора меток TimeSeries
+func (r *TopicRouter) Route(labels []prompb.Label) string {
+	for _, rule := range r.rules {
+		for _, label := range labels {
+			if label.Name == rule.label && rule.pattern.MatchString(label.Value) {
+				return rule.topic
+			}
+		}
+	}
+	return r.defaultTopic
+}
+
+// Topics возвращает все топики, в которые этот router может направить метрику —
+// топик по умолчанию и топики всех настроенных правил, без дублей. Используется
+// при старте, чтобы под каждый топик, на который может приземлиться METRICS_TOPIC_ROUTES,
+// был поднят consumeTopic, иначе маршрутизация в кастомный топик превращается в
+// молчаливый data sink без потребителя и учета лага.
+func (r *TopicRouter) Topics() []string {
+	seen := map[string]bool{r.defaultTopic: true}
+	topics := []string{r.defaultTopic}
+
+	for _, rule := range r.rules {
+		if seen[rule.topic] {
+			continue
+		}
+		seen[rule.topic] = true
+		topics = append(topics, rule.topic)
+	}
+
+	return topics
+}
+
+// isMetricsTopic сообщает, относится ли топик к метрикам (топик по умолчанию или один
+// из METRICS_TOPIC_ROUTES) — такие топики несут сырые metricSample-сэмплы, а не
+// MovieEvent/UserEvent/PaymentEvent, и их не нужно прогонять через Codec.Decode.
+func isMetricsTopic(topic string) bool {
+	for _, metricsTopic := range getMetricsRouter().Topics() {
+		if topic == metricsTopic {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTopicRouterRules загружает правила маршрутизации из переменной окружения METRICS_TOPIC_ROUTES (JSON)
+func loadTopicRouterRules() []TopicRouterRule {
+	raw := getEnv("METRICS_TOPIC_ROUTES", "")
+	if raw == "" {
+		return nil
+	}
+
+	var rules []TopicRouterRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		log.Printf("Ошибка разбора METRICS_TOPIC_ROUTES: %v, правила маршрутизации не применены", err)
+		return nil
+	}
+
+	return rules
+}
+
+// getMetricsRouter возвращает singleton TopicRouter для метрик
+func getMetricsRouter() *TopicRouter {
+	metricsRouterOnce.Do(func() {
+		rules := loadTopicRouterRules()
+		defaultTopic := getEnv("METRICS_DEFAULT_TOPIC", defaultMetricsTopic)
+
+		router, err := NewTopicRouter(rules, defaultTopic)
+		if err != nil {
+			log.Printf("Ошибка инициализации TopicRouter: %v, используется топик по умолчанию", err)
+			router = &TopicRouter{defaultTopic: defaultTopic}
+		}
+		metricsRouter = router
+	})
+	return metricsRouter
+}
+
+// Codec сериализует/десериализует события для отправки в Kafka и обратно.
+// Реализации: jsonCodec (поведение по умолчанию) и schemaCodec (Avro/Protobuf
+// через Schema Registry).
+type Codec interface {
+	Name() string
+	Encode(topic string, eventData interface{}) ([]byte, error)
+	Decode(topic string, data []byte) (interface{}, error)
+}
+
+var (
+	activeCodec     Codec
+	activeCodecOnce sync.Once
+)
+
+// getCodec возвращает активный Codec, выбранный переменной окружения EVENT_SERIALIZATION
+func getCodec() Codec {
+	activeCodecOnce.Do(func() {
+		switch getEnv("EVENT_SERIALIZATION", "json") {
+		case "avro":
+			activeCodec = newSchemaCodec("avro")
+		case "protobuf":
+			activeCodec = newSchemaCodec("protobuf")
+		default:
+			activeCodec = jsonCodec{}
+		}
+		log.Printf("Кодек событий: %s", activeCodec.Name())
+	})
+	return activeCodec
+}
+
+// newEventForTopic создает пустую структуру события нужного типа для указанного топика
+func newEventForTopic(topic string) (interface{}, error) {
+	switch topic {
+	case movieTopic:
+		return &MovieEvent{}, nil
+	case userTopic:
+		return &UserEvent{}, nil
+	case paymentTopic:
+		return &PaymentEvent{}, nil
+	default:
+		return nil, fmt.Errorf("неизвестный топик для десериализации события: %s", topic)
+	}
+}
+
+// jsonCodec сохраняет исходное поведение сервиса: события сериализуются как обычный JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Encode(_ string, eventData interface{}) ([]byte, error) {
+	return json.Marshal(eventData)
+}
+
+func (jsonCodec) Decode(topic string, data []byte) (interface{}, error) {
+	eventData, err := newEventForTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, eventData); err != nil {
+		return nil, err
+	}
+	return eventData, nil
+}
+
+// schemaWireMagicByte — первый байт wire-формата Confluent Schema Registry
+const schemaWireMagicByte = 0x0
+
+// encodeSchemaWireFormat добавляет 5-байтовый префикс (magic byte + 4-байтовый ID схемы)
+func encodeSchemaWireFormat(schemaID int, payload []byte) []byte {
+	header := make([]byte, 5)
+	header[0] = schemaWireMagicByte
+	binary.BigEndian.PutUint32(header[1:], uint32(schemaID))
+	return append(header, payload...)
+}
+
+// decodeSchemaWireFormat разбирает wire-формат Confluent Schema Registry на ID схемы и payload
+func decodeSchemaWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < 5 || data[0] != schemaWireMagicByte {
+		return 0, nil, fmt.Errorf("некорректный schema registry wire-формат")
+	}
+	schemaID := int(binary.BigEndian.Uint32(data[1:5]))
+	return schemaID, data[5:], nil
+}
+
+// avroSchemaFor возвращает Avro-схему для события, публикуемого в указанный топик
+func avroSchemaFor(topic string) string {
+	switch topic {
+	case movieTopic:
+		return `{"type":"record","name":"MovieEvent","fields":[
+			{"name":"movie_id","type":"int"},
+			{"name":"title","type":"string"},
+			{"name":"action","type":"string"},
+			{"name":"user_id","type":"int"}
+		]}`
+	case userTopic:
+		return `{"type":"record","name":"UserEvent","fields":[
+			{"name":"user_id","type":"int"},
+			{"name":"username","type":"string"},
+			{"name":"action","type":"string"},
+			{"name":"timestamp","type":"string"}
+		]}`
+	case paymentTopic:
+		return `{"type":"record","name":"PaymentEvent","fields":[
+			{"name":"payment_id","type":"int"},
+			{"name":"user_id","type":"int"},
+			{"name":"amount","type":"double"},
+			{"name":"status","type":"string"},
+			{"name":"timestamp","type":"string"}
+		]}`
+	default:
+		return `{"type":"record","name":"GenericEvent","fields":[]}`
+	}
+}
+
+// protoSchemaFor возвращает proto3-схему для события, публикуемого в указанный топик.
+// Поля зеркалят avroSchemaFor, чтобы Avro- и Protobuf-контракты одного события не расходились
+// и чтобы каждый subject в Schema Registry описывал реальные поля события, а не общий
+// google.protobuf.Struct, непригодный для тайпчекинга в других языках.
+func protoSchemaFor(topic string) string {
+	switch topic {
+	case movieTopic:
+		return `syntax = "proto3";
+
+package cinemaabyss.events;
+
+message MovieEvent {
+  int32 movie_id = 1;
+  string title = 2;
+  string action = 3;
+  int32 user_id = 4;
+}
+`
+	case userTopic:
+		return `syntax = "proto3";
+
+package cinemaabyss.events;
+
+message UserEvent {
+  int32 user_id = 1;
+  string username = 2;
+  string action = 3;
+  string timestamp = 4;
+}
+`
+	case paymentTopic:
+		return `syntax = "proto3";
+
+package cinemaabyss.events;
+
+message PaymentEvent {
+  int32 payment_id = 1;
+  int32 user_id = 2;
+  double amount = 3;
+  string status = 4;
+  string timestamp = 5;
+}
+`
+	default:
+		return `syntax = "proto3";
+
+package cinemaabyss.events;
+
+message GenericEvent {
+}
+`
+	}
+}
+
+// protoField строит FieldDescriptorProto для одного поля protoMessageDescriptorFor
+func protoField(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     kind.Enum(),
+		Label:    label.Enum(),
+		JsonName: proto.String(name),
+	}
+}
+
+// protoMessageDescriptorFor строит MessageDescriptor для события указанного топика
+// программно через descriptorpb, зеркаля поля protoSchemaFor. Без этого descriptor'а
+// encodeProtobufEvent/decodeProtobufEvent не знают, как разложить native map по
+// типизированным protobuf-полям — без сгенерированных .pb.go это заменяет protoc-шаг.
+func protoMessageDescriptorFor(topic string) (protoreflect.MessageDescriptor, error) {
+	messageName := "GenericEvent"
+	var fields []*descriptorpb.FieldDescriptorProto
+
+	switch topic {
+	case movieTopic:
+		messageName = "MovieEvent"
+		fields = []*descriptorpb.FieldDescriptorProto{
+			protoField("movie_id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			protoField("title", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("action", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("user_id", 4, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+		}
+	case userTopic:
+		messageName = "UserEvent"
+		fields = []*descriptorpb.FieldDescriptorProto{
+			protoField("user_id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			protoField("username", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("action", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("timestamp", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		}
+	case paymentTopic:
+		messageName = "PaymentEvent"
+		fields = []*descriptorpb.FieldDescriptorProto{
+			protoField("payment_id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			protoField("user_id", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			protoField("amount", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+			protoField("status", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("timestamp", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		}
+	}
+
+	fileProto := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String(topic + ".proto"),
+		Syntax:      proto.String("proto3"),
+		Package:     proto.String("cinemaabyss.events"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String(messageName), Field: fields}},
+	}
+
+	file, err := protodesc.NewFile(fileProto, nil)
+	if err != nil {
+		return nil, fmt.Errorf("построение proto-дескриптора для топика %s: %w", topic, err)
+	}
+	return file.Messages().Get(0), nil
+}
+
+// schemaSourceFor возвращает схему, которую реально сериализует Encode для данного формата:
+// Avro- либо Protobuf-схему конкретного события.
+func (c *schemaCodec) schemaSourceFor(topic string) string {
+	if c.format == "protobuf" {
+		return protoSchemaFor(topic)
+	}
+	return avroSchemaFor(topic)
+}
+
+// schemaCodec сериализует события как Avro или Protobuf, регистрируя схемы в Schema Registry
+// и проставляя wire-формат Confluent (magic byte + schema ID) перед payload'ом.
+type schemaCodec struct {
+	format   string // "avro" или "protobuf"
+	registry *SchemaRegistryClient
+
+	mu        sync.RWMutex
+	schemaIDs map[string]int // топик -> ID зарегистрированной схемы
+}
+
+func newSchemaCodec(format string) *schemaCodec {
+	return &schemaCodec{
+		format:    format,
+		registry:  NewSchemaRegistryClient(getEnv("SCHEMA_REGISTRY_URL", "http://localhost:8081")),
+		schemaIDs: map[string]int{},
+	}
+}
+
+func (c *schemaCodec) Name() string { return c.format }
+
+// registerSchemas регистрирует схемы для movie/user/payment событий на старте сервиса
+func (c *schemaCodec) registerSchemas() {
+	compatibility := getEnv("SCHEMA_COMPATIBILITY", "BACKWARD")
+
+	for _, topic := range []string{movieTopic, userTopic, paymentTopic} {
+		subject := topic + "-value"
+		schema := c.schemaSourceFor(topic)
+
+		id, err := c.registry.Register(subject, schema, c.schemaType(), compatibility)
+		if err != nil {
+			log.Printf("Ошибка регистрации схемы для %s: %v", subject, err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.schemaIDs[topic] = id
+		c.mu.Unlock()
+
+		log.Printf("Зарегистрирована схема %s (subject=%s, id=%d)", c.format, subject, id)
+	}
+}
+
+func (c *schemaCodec) schemaType() string {
+	if c.format == "protobuf" {
+		return "PROTOBUF"
+	}
+	return "AVRO"
+}
+
+func (c *schemaCodec) schemaIDFor(topic string) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id, ok := c.schemaIDs[topic]
+	return id, ok
+}
+
+func (c *schemaCodec) Encode(topic string, eventData interface{}) ([]byte, error) {
+	schemaID, ok := c.schemaIDFor(topic)
+	if !ok {
+		return nil, fmt.Errorf("схема для топика %s не зарегистрирована", topic)
+	}
+
+	native, err := eventToNativeMap(eventData)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	switch c.format {
+	case "avro":
+		coerceAvroIntFields(topic, native)
+		payload, err = encodeAvro(avroSchemaFor(topic), native)
+	case "protobuf":
+		payload, err = encodeProtobufEvent(topic, native)
+	default:
+		return nil, fmt.Errorf("неподдерживаемый формат кодека: %s", c.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeSchemaWireFormat(schemaID, payload), nil
+}
+
+func (c *schemaCodec) Decode(topic string, data []byte) (interface{}, error) {
+	_, payload, err := decodeSchemaWireFormat(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var native map[string]interface{}
+	switch c.format {
+	case "avro":
+		native, err = decodeAvro(avroSchemaFor(topic), payload)
+	case "protobuf":
+		native, err = decodeProtobufEvent(topic, payload)
+	default:
+		return nil, fmt.Errorf("неподдерживаемый формат кодека: %s", c.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nativeMapToEvent(topic, native)
+}
+
+// eventToNativeMap переводит типизированное событие в map[string]interface{} через JSON-roundtrip
+func eventToNativeMap(eventData interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(eventData)
+	if err != nil {
+		return nil, err
+	}
+	native := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &native); err != nil {
+		return nil, err
+	}
+	return native, nil
+}
+
+// nativeMapToEvent переводит map[string]interface{} обратно в типизированную структуру события
+func nativeMapToEvent(topic string, native map[string]interface{}) (interface{}, error) {
+	eventData, err := newEventForTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(native)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, eventData); err != nil {
+		return nil, err
+	}
+	return eventData, nil
+}
+
+// avroIntFieldsFor возвращает имена полей, объявленных в avroSchemaFor как Avro "int"
+func avroIntFieldsFor(topic string) []string {
+	switch topic {
+	case movieTopic:
+		return []string{"movie_id", "user_id"}
+	case userTopic:
+		return []string{"user_id"}
+	case paymentTopic:
+		return []string{"payment_id", "user_id"}
+	default:
+		return nil
+	}
+}
+
+// coerceAvroIntFields приводит к int32 поля, которые eventToNativeMap получил через
+// JSON-roundtrip как float64, но которые avroSchemaFor объявляет типом "int" — goavro
+// сверяет Go-тип значения с типом схемы и не кодирует float64 в поле "int".
+func coerceAvroIntFields(topic string, native map[string]interface{}) {
+	for _, field := range avroIntFieldsFor(topic) {
+		if value, ok := native[field].(float64); ok {
+			native[field] = int32(value)
+		}
+	}
+}
+
+// encodeAvro сериализует native map в Avro binary по переданной схеме
+func encodeAvro(schema string, native map[string]interface{}) ([]byte, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+	return codec.BinaryFromNative(nil, native)
+}
+
+// decodeAvro десериализует Avro binary в native map по переданной схеме
+func decodeAvro(schema string, payload []byte) (map[string]interface{}, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, err
+	}
+	asMap, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("неожиданный тип значения Avro: %T", native)
+	}
+	return asMap, nil
+}
+
+// encodeProtobufEvent сериализует native map в protobuf-сообщение по дескриптору конкретного
+// события (protoMessageDescriptorFor), так что байты на проводе соответствуют схеме,
+// зарегистрированной для этого топика в Schema Registry.
+func encodeProtobufEvent(topic string, native map[string]interface{}) ([]byte, error) {
+	descriptor, err := protoMessageDescriptorFor(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	message := dynamicpb.NewMessage(descriptor)
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		value, ok := native[string(field.Name())]
+		if !ok || value == nil {
+			continue
+		}
+		protoValue, err := protoValueFor(field, value)
+		if err != nil {
+			return nil, fmt.Errorf("поле %s: %w", field.Name(), err)
+		}
+		message.Set(field, protoValue)
+	}
+
+	return proto.Marshal(message)
+}
+
+// protoValueFor приводит JSON-roundtripped значение (float64 для чисел, string для строк)
+// к типу, объявленному в дескрипторе поля
+func protoValueFor(field protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.Int32Kind:
+		number, ok := value.(float64)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("ожидалось число, получено %T", value)
+		}
+		return protoreflect.ValueOfInt32(int32(number)), nil
+	case protoreflect.DoubleKind:
+		number, ok := value.(float64)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("ожидалось число, получено %T", value)
+		}
+		return protoreflect.ValueOfFloat64(number), nil
+	case protoreflect.StringKind:
+		str, ok := value.(string)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("ожидалась строка, получено %T", value)
+		}
+		return protoreflect.ValueOfString(str), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("неподдерживаемый тип поля %s", field.Kind())
+	}
+}
+
+// decodeProtobufEvent десериализует protobuf-сообщение события обратно в native map
+// по тому же дескриптору, по которому оно было закодировано
+func decodeProtobufEvent(topic string, payload []byte) (map[string]interface{}, error) {
+	descriptor, err := protoMessageDescriptorFor(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, err
+	}
+
+	native := map[string]interface{}{}
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if !message.Has(field) {
+			continue
+		}
+		value := message.Get(field)
+		switch field.Kind() {
+		case protoreflect.Int32Kind:
+			native[string(field.Name())] = float64(value.Int())
+		case protoreflect.DoubleKind:
+			native[string(field.Name())] = value.Float()
+		case protoreflect.StringKind:
+			native[string(field.Name())] = value.String()
+		}
+	}
+	return native, nil
+}
+
+// SchemaRegistryClient — минимальный REST-клиент Confluent-совместимого Schema Registry
+type SchemaRegistryClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewSchemaRegistryClient создает клиент Schema Registry с заданным базовым URL
+func NewSchemaRegistryClient(baseURL string) *SchemaRegistryClient {
+	return &SchemaRegistryClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// registerSchemaRequest соответствует телу POST /subjects/{subject}/versions
+type registerSchemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+type registerSchemaResponse struct {
+	ID int `json:"id"`
+}
+
+// Register регистрирует схему под указанным subject'ом и возвращает присвоенный ID
+func (c *SchemaRegistryClient) Register(subject, schema, schemaType, compatibility string) (int, error) {
+	body, err := json.Marshal(registerSchemaRequest{Schema: schema, SchemaType: schemaType})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/subjects/%s/versions", c.baseURL, subject), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("schema registry вернул статус %d при регистрации %s", resp.StatusCode, subject)
+	}
+
+	var parsed registerSchemaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return 0, err
+	}
+
+	if compatibility != "" {
+		c.setCompatibility(subject, compatibility)
+	}
+
+	return parsed.ID, nil
+}
+
+// setCompatibility выставляет уровень совместимости для subject'а (лучшее усилие, ошибки только логируются)
+func (c *SchemaRegistryClient) setCompatibility(subject, compatibility string) {
+	body, _ := json.Marshal(map[string]string{"compatibility": compatibility})
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/config/%s", c.baseURL, subject), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Ошибка установки SCHEMA_COMPATIBILITY для %s: %v", subject, err)
+		return
+	}
+	defer resp.Body.Close()
+}
+
+// Subjects возвращает список известных Schema Registry subject'ов
+func (c *SchemaRegistryClient) Subjects() ([]string, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/subjects")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var subjects []string
+	if err := json.NewDecoder(resp.Body).Decode(&subjects); err != nil {
+		return nil, err
+	}
+	return subjects, nil
+}
+
+// Versions возвращает список версий, зарегистрированных для subject'а
+func (c *SchemaRegistryClient) Versions(subject string) ([]int, error) {
+	resp, err := c.httpClient.Get(fmt.Sprintf("%s/subjects/%s/versions", c.baseURL, subject))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var versions []int
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// handleListSchemas отдает список зарегистрированных subject'ов и их версий
+func handleListSchemas(w http.ResponseWriter, r *http.Request) {
+	codec := getCodec()
+	sc, ok := codec.(*schemaCodec)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"serialization": codec.Name(),
+			"subjects":      []string{},
+		})
+		return
+	}
+
+	subjects, err := sc.registry.Subjects()
+	if err != nil {
+		http.Error(w, "Не удалось получить список subject'ов из Schema Registry", http.StatusBadGateway)
+		return
+	}
+
+	result := make(map[string][]int, len(subjects))
+	for _, subject := range subjects {
+		versions, err := sc.registry.Versions(subject)
+		if err != nil {
+			log.Printf("Ошибка получения версий для %s: %v", subject, err)
+			continue
+		}
+		result[subject] = versions
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"serialization": codec.Name(),
+		"subjects":      result,
+	})
+}
+
+// jwk — один ключ из JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// publicKey декодирует JWK RSA-ключ в *rsa.PublicKey
+func (k jwk) publicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный модуль RSA в JWK %s: %w", k.Kid, err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("некорректная экспонента RSA в JWK %s: %w", k.Kid, err)
+	}
+
+	exponent := 0
+	for _, b := range eBytes {
+		exponent = exponent<<8 | int(b)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: exponent,
+	}, nil
+}
+
+// JWKSValidator проверяет JWT, подписанные ключами из JWKS указанного OIDC issuer'а,
+// и периодически обновляет набор ключей.
+type JWKSValidator struct {
+	issuer     string
+	httpClient *http.Client
+	ttl        time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSValidator создает валидатор для указанного OIDC issuer'а
+func NewJWKSValidator(issuer string) *JWKSValidator {
+	return &JWKSValidator{
+		issuer:     strings.TrimRight(issuer, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		ttl:        10 * time.Minute,
+		keys:       map[string]*rsa.PublicKey{},
+	}
+}
+
+// refreshIfNeeded перезапрашивает JWKS, если кэш устарел
+func (v *JWKSValidator) refreshIfNeeded() error {
+	v.mu.RLock()
+	stale := time.Since(v.fetchedAt) >= v.ttl
+	v.mu.RUnlock()
+	if !stale {
+		return nil
+	}
+
+	resp, err := v.httpClient.Get(v.issuer + "/.well-known/jwks.json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			log.Printf("Пропущен JWK %s: %v", key.Kid, err)
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	return nil
+}
+
+// Validate проверяет подпись и срок действия JWT и возвращает его claims
+func (v *JWKSValidator) Validate(tokenString string) (jwt.MapClaims, error) {
+	if err := v.refreshIfNeeded(); err != nil {
+		return nil, fmt.Errorf("не удалось обновить JWKS: %w", err)
+	}
+
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		v.mu.RLock()
+		key, ok := v.keys[kid]
+		v.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("неизвестный kid: %s", kid)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("недействительный токен")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("неожиданный формат claims токена")
+	}
+	return claims, nil
+}
+
+var (
+	jwksValidator     *JWKSValidator
+	jwksValidatorOnce sync.Once
 )
 
+// getJWKSValidator возвращает singleton JWKSValidator для OIDC_ISSUER
+func getJWKSValidator() *JWKSValidator {
+	jwksValidatorOnce.Do(func() {
+		jwksValidator = NewJWKSValidator(getEnv("OIDC_ISSUER", ""))
+	})
+	return jwksValidator
+}
+
+// hasScope проверяет наличие scope в claim "scope" (строка через пробел) или "scp" (массив строк)
+func hasScope(claims jwt.MapClaims, requiredScope string) bool {
+	switch scopeClaim := claims["scope"].(type) {
+	case string:
+		for _, scope := range strings.Fields(scopeClaim) {
+			if scope == requiredScope {
+				return true
+			}
+		}
+	}
+
+	if scopes, ok := claims["scp"].([]interface{}); ok {
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok && s == requiredScope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// scopeForTopic возвращает требуемый OAuth scope для публикации события в данный топик
+func scopeForTopic(topic string) string {
+	switch topic {
+	case movieTopic:
+		return "events:movie:write"
+	case userTopic:
+		return "events:user:write"
+	case paymentTopic:
+		return "events:payment:write"
+	default:
+		return "events:" + topic + ":write"
+	}
+}
+
+// requireScope оборачивает обработчик проверкой JWT (против JWKS OIDC_ISSUER) и требуемого scope.
+// Если OIDC_ISSUER не настроен, проверка отключена (для локальной разработки/тестов).
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if getEnv("OIDC_ISSUER", "") == "" {
+			next(w, r)
+			return
+		}
+
+		authHeader := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			http.Error(w, "Требуется заголовок Authorization: Bearer", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := getJWKSValidator().Validate(strings.TrimPrefix(authHeader, "Bearer "))
+		if err != nil {
+			http.Error(w, "Недействительный токен: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if !hasScope(claims, scope) {
+			http.Error(w, "Недостаточно прав для scope "+scope, http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// identityHeaders переносит входящий Bearer-токен в заголовки сообщения Kafka,
+// чтобы downstream-потребители видели исходную identity вызывающей стороны.
+func identityHeaders(r *http.Request) []kafka.Header {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil
+	}
+	return []kafka.Header{{Key: "x-identity-token", Value: []byte(authHeader)}}
+}
+
+// getEnv возвращает значение переменной окружения или значение по умолчанию
+func getEnv(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return fallback
+}
+
+// buildKafkaTLSConfig собирает tls.Config из KAFKA_TLS_CA/CERT/KEY. Возвращает nil, если ни одна
+// из переменных не задана — в этом случае соединение с Kafka идет без TLS.
+func buildKafkaTLSConfig() (*tls.Config, error) {
+	caPath := getEnv("KAFKA_TLS_CA", "")
+	certPath := getEnv("KAFKA_TLS_CERT", "")
+	keyPath := getEnv("KAFKA_TLS_KEY", "")
+
+	if caPath == "" && certPath == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if caPath != "" {
+		caCert, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать KAFKA_TLS_CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("не удалось разобрать сертификат KAFKA_TLS_CA")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certPath != "" && keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось загрузить KAFKA_TLS_CERT/KAFKA_TLS_KEY: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// buildKafkaSASLMechanism строит SASL/SCRAM механизм из KAFKA_SASL_MECHANISM/KAFKA_SASL_USERNAME/KAFKA_SASL_PASSWORD.
+// Возвращает nil, если KAFKA_SASL_MECHANISM не задан — аутентификация SASL не используется.
+func buildKafkaSASLMechanism() (sasl.Mechanism, error) {
+	mechanismName := getEnv("KAFKA_SASL_MECHANISM", "")
+	if mechanismName == "" {
+		return nil, nil
+	}
+
+	username := getEnv("KAFKA_SASL_USERNAME", "")
+	password := getEnv("KAFKA_SASL_PASSWORD", "")
+
+	var algorithm scram.Algorithm
+	switch mechanismName {
+	case "SCRAM-SHA-256":
+		algorithm = scram.SHA256
+	case "SCRAM-SHA-512":
+		algorithm = scram.SHA512
+	default:
+		return nil, fmt.Errorf("неподдерживаемый KAFKA_SASL_MECHANISM: %s", mechanismName)
+	}
+
+	return scram.Mechanism(algorithm, username, password)
+}
+
+// newKafkaDialer создает kafka.Dialer для consumer'ов с учетом TLS/SASL
+func newKafkaDialer() (*kafka.Dialer, error) {
+	tlsConfig, err := buildKafkaTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := buildKafkaSASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}, nil
+}
+
+// newKafkaTransport создает kafka.Transport для producer'ов с учетом TLS/SASL
+func newKafkaTransport() (*kafka.Transport, error) {
+	tlsConfig, err := buildKafkaTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := buildKafkaSASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		TLS:  tlsConfig,
+		SASL: mechanism,
+	}, nil
+}
+
+// initKafkaWriter инициализирует Kafka writer для конкретного топика с учетом настроек надежности.
+// Поле Topic у writer'а не заполняется: топик несет каждое kafka.Message, а kafka-go отклоняет
+// запись, если топик задан и у writer'а, и у сообщения.
+func initKafkaWriter(topic string, cfg WriterConfig) *kafka.Writer {
+	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
+	brokers := strings.Split(kafkaBrokers, ",")
+
+	writer := &kafka.Writer{
+		Addr:            kafka.TCP(brokers...),
+		Balancer:        &kafka.LeastBytes{},
+		RequiredAcks:    cfg.RequiredAcks,
+		Async:           cfg.Async,
+		BatchTimeout:    cfg.BatchTimeout,
+		MaxAttempts:     cfg.MaxAttempts,
+		WriteBackoffMin: cfg.WriteBackoffMin,
+		WriteBackoffMax: cfg.WriteBackoffMax,
+	}
+
+	if transport, err := newKafkaTransport(); err != nil {
+		log.Printf("Ошибка настройки TLS/SASL для Kafka writer'а топика %s: %v, используется соединение без аутентификации", topic, err)
+	} else if transport != nil {
+		writer.Transport = transport
+	}
+
+	if cfg.Async {
+		writer.Completion = func(messages []kafka.Message, err error) {
+			if err == nil {
+				return
+			}
+			for _, message := range messages {
+				log.Printf("Ошибка доставки в топик %s: %v, отправка в DLQ", message.Topic, err)
+				if dlqErr := publishToDLQ(message.Topic, message.Value, err, dlqRetryCount(message.Headers)); dlqErr != nil {
+					log.Printf("Ошибка отправки в DLQ для топика %s: %v", message.Topic, dlqErr)
+				}
+			}
+		}
+	}
+
+	return writer
+}
+
+// getTopicWriter возвращает (создавая при необходимости) writer для указанного топика
+func getTopicWriter(topic string) *kafka.Writer {
+	topicWritersMu.Lock()
+	defer topicWritersMu.Unlock()
+
+	if writer, ok := topicWriters[topic]; ok {
+		return writer
+	}
+
+	writer := initKafkaWriter(topic, loadWriterConfig())
+	topicWriters[topic] = writer
+	return writer
+}
+
 var (
-	kafkaWriter *kafka.Writer
-	once        sync.Once
+	syncTopicWriters   = map[string]*kafka.Writer{}
+	syncTopicWritersMu sync.Mutex
 )
 
-// getEnv возвращает значение переменной окружения или значение по умолчанию
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// getSyncTopicWriter возвращает (создавая один раз на топик и переиспользуя далее) синхронный
+// writer с RequiredAcks=all — используется явным ?sync=true путем в sendToKafka, health-check
+// пробой задержки записи и publishToDLQ, которым важно дождаться подтверждения от брокера.
+// Поскольку writer синхронный, initKafkaWriter не навешивает на него Completion: ошибка
+// записи в DLQ-топик возвращается вызывающему, а не уходит в повторный publishToDLQ,
+// иначе недоступность Kafka заставила бы DLQ бесконечно переотправлять сам себя
+// (topic.dlq -> topic.dlq.dlq -> ...).
+func getSyncTopicWriter(topic string) *kafka.Writer {
+	syncTopicWritersMu.Lock()
+	defer syncTopicWritersMu.Unlock()
+
+	if writer, ok := syncTopicWriters[topic]; ok {
+		return writer
+	}
+
+	cfg := loadWriterConfig()
+	cfg.Async = false
+	writer := initKafkaWriter(topic, cfg)
+	syncTopicWriters[topic] = writer
+	return writer
+}
+
+// closeTopicWriters закрывает все созданные writer'ы, синхронные и асинхронные
+func closeTopicWriters() {
+	topicWritersMu.Lock()
+	for topic, writer := range topicWriters {
+		if err := writer.Close(); err != nil {
+			log.Printf("Ошибка закрытия writer'а для топика %s: %v", topic, err)
+		}
+	}
+	topicWritersMu.Unlock()
+
+	syncTopicWritersMu.Lock()
+	for topic, writer := range syncTopicWriters {
+		if err := writer.Close(); err != nil {
+			log.Printf("Ошибка закрытия синхронного writer'а для топика %s: %v", topic, err)
+		}
 	}
-	return fallback
+	syncTopicWritersMu.Unlock()
 }
 
-// initKafkaWriter инициализирует Kafka writer (используется паттерн singleton)
-func initKafkaWriter() *kafka.Writer {
-	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
-	brokers := strings.Split(kafkaBrokers, ",")
+// publishToDLQ переотправляет недоставленное сообщение в dead-letter топик с диагностическими заголовками
+func publishToDLQ(originalTopic string, payload []byte, cause error, retryCount int) error {
+	dlqTopic := originalTopic + dlqSuffix
+	writer := getSyncTopicWriter(dlqTopic)
 
-	return &kafka.Writer{
-		Addr:     kafka.TCP(brokers...),
-		Balancer: &kafka.LeastBytes{},
+	message := kafka.Message{
+		Topic: dlqTopic,
+		Value: payload,
+		Headers: []kafka.Header{
+			{Key: "x-original-topic", Value: []byte(originalTopic)},
+			{Key: "x-error", Value: []byte(cause.Error())},
+			{Key: "x-retry-count", Value: []byte(strconv.Itoa(retryCount))},
+			{Key: "x-produced-at", Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+		},
 	}
-}
 
-// getKafkaWriter возвращает экземпляр Kafka writer
-func getKafkaWriter() *kafka.Writer {
-	once.Do(func() {
-		kafkaWriter = initKafkaWriter()
-	})
-	return kafkaWriter
+	return writer.WriteMessages(context.Background(), message)
 }
 
 func main() {
-	// Инициализация Kafka writer
-	writer := getKafkaWriter()
-	defer writer.Close()
+	// Writer'ы создаются лениво по топикам, закрываем все при завершении
+	defer closeTopicWriters()
 
-	// Запуск потребителей для каждого топика
+	// Если сериализация событий — Avro/Protobuf, регистрируем схемы в Schema Registry на старте
+	if codec := getCodec(); codec.Name() == "avro" || codec.Name() == "protobuf" {
+		codec.(*schemaCodec).registerSchemas()
+	}
+
+	// Контекст, отменяемый по SIGINT/SIGTERM, останавливает потребителей и инициирует
+	// graceful shutdown HTTP сервера: буферы sink'ов сбрасываются и оффсеты коммитятся
+	// до завершения main
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Запуск потребителей для каждого топика, включая все топики, настроенные
+	// через METRICS_TOPIC_ROUTES — иначе метрики, разложенные по кастомным топикам,
+	// остаются без потребителя, sink'а и учета лага
 	var wg sync.WaitGroup
 	topics := []string{movieTopic, userTopic, paymentTopic}
+	topics = append(topics, getMetricsRouter().Topics()...)
 
 	for _, topic := range topics {
 		wg.Add(1)
-		go consumeTopic(context.Background(), topic, &wg)
+		go consumeTopic(ctx, topic, &wg)
+	}
+
+	// Фоновые проверки зависимостей для /__health и /__gtg
+	kafkaDialer, err := newKafkaDialer()
+	if err != nil {
+		log.Printf("Ошибка настройки TLS/SASL для healthcheck-соединения с Kafka: %v, используется соединение без аутентификации", err)
+		kafkaDialer = &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true}
 	}
 
+	healthRegistry := healthcheck.NewRegistry([]*healthcheck.Check{
+		newKafkaBrokersCheck(kafkaDialer),
+		newWriterLatencyCheck(),
+		newConsumerLagCheck(),
+	})
+	defer healthRegistry.Stop()
+
 	// Настройка HTTP маршрутов
-	http.HandleFunc("/api/events/movie", handleEvent(movieTopic))
-	http.HandleFunc("/api/events/user", handleEvent(userTopic))
-	http.HandleFunc("/api/events/payment", handleEvent(paymentTopic))
-	http.HandleFunc("/api/events/health", healthCheckHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/events/movie", requireScope(scopeForTopic(movieTopic), handleEvent(movieTopic)))
+	mux.HandleFunc("/api/events/user", requireScope(scopeForTopic(userTopic), handleEvent(userTopic)))
+	mux.HandleFunc("/api/events/payment", requireScope(scopeForTopic(paymentTopic), handleEvent(paymentTopic)))
+	mux.HandleFunc("/api/events/metrics", handleMetricsEvent)
+	mux.HandleFunc("/api/events/dlq/replay", handleDLQReplay)
+	mux.HandleFunc("/api/events/schemas", handleListSchemas)
+	mux.HandleFunc("/__health", healthRegistry.HealthHandler("events-service", func() map[string]interface{} {
+		return map[string]interface{}{"consumerLag": consumerLagByTopic()}
+	}))
+	mux.HandleFunc("/__gtg", healthRegistry.GTGHandler())
+	mux.HandleFunc("/__build-info", healthcheck.BuildInfoHandler("events-service"))
 
-	// Запуск HTTP сервера
 	port := getEnv("PORT", "8082")
-	log.Printf("Сервис событий запускается на порту %s", port)
-	log.Printf("Подключение к Kafka brokers: %s", getEnv("KAFKA_BROKERS", "localhost:9092"))
+	server := &http.Server{Addr: ":" + port, Handler: mux}
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Ошибка запуска сервера: %v", err)
+	go func() {
+		log.Printf("Сервис событий запускается на порту %s", port)
+		log.Printf("Подключение к Kafka brokers: %s", getEnv("KAFKA_BROKERS", "localhost:9092"))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Ошибка запуска сервера: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Получен сигнал остановки, завершение работы...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Ошибка остановки HTTP сервера: %v", err)
 	}
 
 	wg.Wait()
+	log.Printf("Сервис остановлен")
 }
 
 // handleEvent создает обработчик для разных типов событий
@@ -123,31 +1446,126 @@ func handleEvent(topic string) http.HandlerFunc {
 			return
 		}
 
-		// Отправка события в Kafka
-		if err := sendToKafka(topic, eventData); err != nil {
+		// ?sync=true заставляет ждать подтверждения доставки от Kafka перед ответом
+		sync := r.URL.Query().Get("sync") == "true"
+
+		if err := sendToKafka(topic, eventData, sync, identityHeaders(r)); err != nil {
 			log.Printf("Ошибка отправки в Kafka: %v", err)
 			http.Error(w, "Ошибка обработки события", http.StatusInternalServerError)
 			return
 		}
 
-		// Успешный ответ
-		sendSuccessResponse(w, topic, eventData)
+		// Успешный ответ: 202 для асинхронной отправки, 201 для синхронной
+		if sync || !getTopicWriter(topic).Async {
+			sendSuccessResponse(w, topic, eventData)
+		} else {
+			sendAcceptedResponse(w, topic, eventData)
+		}
 	}
 }
 
-// parseEventData парсит тело запроса в соответствующую структуру
-func parseEventData(topic string, r *http.Request) (interface{}, error) {
-	var eventData interface{}
+// handleMetricsEvent принимает Prometheus remote_write запросы и раскладывает сэмплы по топикам Kafka
+func handleMetricsEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
 
-	switch topic {
-	case movieTopic:
-		eventData = &MovieEvent{}
-	case userTopic:
-		eventData = &UserEvent{}
-	case paymentTopic:
-		eventData = &PaymentEvent{}
-	default:
-		return nil, &json.UnsupportedTypeError{}
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Не удалось прочитать тело запроса", http.StatusBadRequest)
+		return
+	}
+
+	decoded, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, "Не удалось распаковать remote_write payload", http.StatusBadRequest)
+		return
+	}
+
+	var writeRequest prompb.WriteRequest
+	if err := protobuflib.Unmarshal(decoded, &writeRequest); err != nil {
+		http.Error(w, "Не удалось разобрать prompb.WriteRequest", http.StatusBadRequest)
+		return
+	}
+
+	router := getMetricsRouter()
+	samplesSent := 0
+
+	for _, series := range writeRequest.Timeseries {
+		topic := router.Route(series.Labels)
+		key := fingerprintLabels(series.Labels)
+
+		for _, sample := range series.Samples {
+			if err := sendMetricSampleToKafka(topic, key, series.Labels, sample); err != nil {
+				log.Printf("Ошибка отправки метрики в топик %s: %v", topic, err)
+				http.Error(w, "Ошибка обработки метрики", http.StatusInternalServerError)
+				return
+			}
+			samplesSent++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"samples": samplesSent,
+	})
+}
+
+// metricSample представляет один сэмпл временного ряда для сериализации в Kafka
+type metricSample struct {
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp_ms"`
+}
+
+// fingerprintLabels вычисляет стабильный отпечаток набора меток (FNV-1a) для ключа партиционирования
+func fingerprintLabels(labels []prompb.Label) []byte {
+	h := fnv.New64a()
+	for _, label := range labels {
+		h.Write([]byte(label.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(label.Value))
+		h.Write([]byte{0})
+	}
+	return []byte(fmt.Sprintf("%x", h.Sum64()))
+}
+
+// sendMetricSampleToKafka сериализует и отправляет один сэмпл метрики в указанный топик, с ключом по отпечатку
+func sendMetricSampleToKafka(topic string, key []byte, labels []prompb.Label, sample prompb.Sample) error {
+	labelMap := make(map[string]string, len(labels))
+	for _, label := range labels {
+		labelMap[label.Name] = label.Value
+	}
+
+	payload, err := json.Marshal(metricSample{
+		Labels:    labelMap,
+		Value:     sample.Value,
+		Timestamp: sample.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	writer := getTopicWriter(topic)
+	message := kafka.Message{
+		Topic: topic,
+		Key:   key,
+		Value: payload,
+	}
+
+	return writer.WriteMessages(context.Background(), message)
+}
+
+// parseEventData парсит тело запроса в соответствующую структуру. Тело HTTP-запроса
+// остается обычным JSON независимо от EVENT_SERIALIZATION — кодек применяется только
+// к представлению события в Kafka (см. sendToKafka/consumeTopic).
+func parseEventData(topic string, r *http.Request) (interface{}, error) {
+	eventData, err := newEventForTopic(topic)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(eventData); err != nil {
@@ -157,20 +1575,41 @@ func parseEventData(topic string, r *http.Request) (interface{}, error) {
 	return eventData, nil
 }
 
-// sendToKafka отправляет событие в Kafka
-func sendToKafka(topic string, eventData interface{}) error {
-	eventBytes, err := json.Marshal(eventData)
+// sendToKafka отправляет событие в Kafka. При sync=true используется writer с синхронным
+// режимом (ожидание RequiredAcks), при sync=false и асинхронном writer'е топика сообщение
+// уходит асинхронно и ошибки доставки обрабатываются через Completion callback writer'а
+// (см. initKafkaWriter). Если writer топика синхронный (KAFKA_WRITER_ASYNC=false),
+// отправка идет синхронным путем и при sync=false.
+func sendToKafka(topic string, eventData interface{}, sync bool, headers []kafka.Header) error {
+	eventBytes, err := getCodec().Encode(topic, eventData)
 	if err != nil {
 		return err
 	}
 
-	writer := getKafkaWriter()
+	writer := getTopicWriter(topic)
 	message := kafka.Message{
-		Topic: topic,
-		Value: eventBytes,
+		Topic:   topic,
+		Value:   eventBytes,
+		Headers: headers,
 	}
 
-	return writer.WriteMessages(context.Background(), message)
+	if !sync && writer.Async {
+		return writer.WriteMessages(context.Background(), message)
+	}
+
+	// Синхронный путь: пишем через общий для топика синхронный writer (создается один раз
+	// и переиспользуется, сохраняя его собственную батчировку), чтобы дождаться подтверждения
+	// доставки независимо от Async-конфигурации основного writer'а.
+	syncWriter := getSyncTopicWriter(topic)
+
+	if err := syncWriter.WriteMessages(context.Background(), message); err != nil {
+		if dlqErr := publishToDLQ(topic, eventBytes, err, dlqRetryCount(headers)); dlqErr != nil {
+			log.Printf("Ошибка отправки в DLQ для топика %s: %v", topic, dlqErr)
+		}
+		return err
+	}
+
+	return nil
 }
 
 // sendSuccessResponse отправляет успешный HTTP ответ
@@ -186,45 +1625,543 @@ func sendSuccessResponse(w http.ResponseWriter, topic string, eventData interfac
 	})
 }
 
-// healthCheckHandler обработчик проверки здоровья сервиса
-func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	response := map[string]interface{}{
-		"status":    true,
-		"service":   "events-service",
-		"timestamp": time.Now().UTC(),
+// sendAcceptedResponse отправляет ответ 202 Accepted для асинхронно отправленных событий
+func sendAcceptedResponse(w http.ResponseWriter, topic string, eventData interface{}) {
+	eventBytes, _ := json.Marshal(eventData)
+	log.Printf("Сообщение принято к отправке в топик %s: %s", topic, string(eventBytes))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "accepted",
+		"message": "Событие принято к асинхронной обработке",
+	})
+}
+
+// handleDLQReplay читает сообщения из DLQ топика и переотправляет их в исходный топик
+// с увеличенным x-retry-count, не превышая настраиваемый потолок повторов.
+func handleDLQReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "Параметр topic обязателен", http.StatusBadRequest)
+		return
+	}
+
+	limit := getEnvInt("DLQ_REPLAY_BATCH_SIZE", 100)
+	maxRetries := getEnvInt("DLQ_REPLAY_MAX_RETRIES", 5)
+
+	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
+	dialer, err := newKafkaDialer()
+	if err != nil {
+		log.Printf("Ошибка настройки TLS/SASL для DLQ replay reader'а: %v, используется соединение без аутентификации", err)
+		dialer = nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  strings.Split(kafkaBrokers, ","),
+		Topic:    topic + dlqSuffix,
+		GroupID:  "cinemaabyss-dlq-replay",
+		MinBytes: 1,
+		MaxBytes: 10e6,
+		Dialer:   dialer,
+	})
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	replayed := 0
+	skipped := 0
+
+	for i := 0; i < limit; i++ {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			break
+		}
+
+		retryCount := dlqRetryCount(message.Headers)
+		if retryCount >= maxRetries {
+			skipped++
+			reader.CommitMessages(ctx, message)
+			continue
+		}
+
+		if err := publishToDLQOrigin(topic, message.Value, retryCount+1); err != nil {
+			log.Printf("Ошибка повторной отправки в топик %s: %v", topic, err)
+			break
+		}
+
+		reader.CommitMessages(ctx, message)
+		replayed++
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"topic":    topic,
+		"replayed": replayed,
+		"skipped":  skipped,
+	})
+}
+
+// dlqRetryCount извлекает значение x-retry-count из заголовков сообщения DLQ
+func dlqRetryCount(headers []kafka.Header) int {
+	for _, header := range headers {
+		if header.Key == "x-retry-count" {
+			count, err := strconv.Atoi(string(header.Value))
+			if err == nil {
+				return count
+			}
+		}
+	}
+	return 0
+}
+
+// publishToDLQOrigin переотправляет сообщение DLQ обратно в исходный топик с обновленным retry-count.
+// Пишет через синхронный writer, чтобы handleDLQReplay коммитил оффсет DLQ только после
+// подтвержденной брокером доставки в исходный топик, а не просто постановки в очередь на отправку.
+func publishToDLQOrigin(originalTopic string, payload []byte, retryCount int) error {
+	writer := getSyncTopicWriter(originalTopic)
+	message := kafka.Message{
+		Topic: originalTopic,
+		Value: payload,
+		Headers: []kafka.Header{
+			{Key: "x-retry-count", Value: []byte(strconv.Itoa(retryCount))},
+			{Key: "x-produced-at", Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+		},
+	}
+	return writer.WriteMessages(context.Background(), message)
+}
+
+// newKafkaBrokersCheck проверяет доступность Kafka через metadata fetch (kafka.Dial)
+func newKafkaBrokersCheck(dialer *kafka.Dialer) *healthcheck.Check {
+	return &healthcheck.Check{
+		Name:           "kafka-brokers",
+		Severity:       healthcheck.SeverityCritical,
+		BusinessImpact: "События невозможно ни опубликовать, ни прочитать, пока Kafka недоступна",
+		Interval:       15 * time.Second,
+		Run: func(ctx context.Context) (string, error) {
+			brokers := strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ",")
+			conn, err := dialer.DialContext(ctx, "tcp", brokers[0])
+			if err != nil {
+				return "", fmt.Errorf("не удалось подключиться к %s: %w", brokers[0], err)
+			}
+			defer conn.Close()
+
+			partitions, err := conn.ReadPartitions()
+			if err != nil {
+				return "", fmt.Errorf("не удалось прочитать метаданные: %w", err)
+			}
+			return fmt.Sprintf("%s доступен, партиций в метаданных: %d", brokers[0], len(partitions)), nil
+		},
+	}
 }
 
-// consumeTopic потребляет сообщения из указанного топика Kafka
+// newWriterLatencyCheck публикует тестовое сообщение в служебный топик и измеряет задержку записи
+func newWriterLatencyCheck() *healthcheck.Check {
+	threshold := getEnvDuration("HEALTHCHECK_WRITER_LATENCY_THRESHOLD", 2*time.Second)
+	healthcheckTopic := getEnv("HEALTHCHECK_TOPIC", "events-service-healthcheck")
+
+	return &healthcheck.Check{
+		Name:           "kafka-writer-latency",
+		Severity:       healthcheck.SeverityWarning,
+		BusinessImpact: "Высокая задержка записи в Kafka ведет к таймаутам у клиентов API событий",
+		Interval:       30 * time.Second,
+		Run: func(ctx context.Context) (string, error) {
+			// Обязательно синхронный writer: асинхронный возвращается сразу после постановки
+			// в очередь, не дожидаясь ack от брокера, и измерял бы только задержку enqueue
+			writer := getSyncTopicWriter(healthcheckTopic)
+
+			start := time.Now()
+			err := writer.WriteMessages(ctx, kafka.Message{
+				Topic: healthcheckTopic,
+				Value: []byte(`{"healthcheck":true}`),
+			})
+			latency := time.Since(start)
+			if err != nil {
+				return "", fmt.Errorf("ошибка записи тестового сообщения: %w", err)
+			}
+			if latency > threshold {
+				return "", fmt.Errorf("задержка записи %s превышает порог %s", latency, threshold)
+			}
+			return fmt.Sprintf("задержка записи: %s", latency), nil
+		},
+	}
+}
+
+// newConsumerLagCheck проверяет, что лаг потребителя по каждому топику не превышает порог
+func newConsumerLagCheck() *healthcheck.Check {
+	threshold := int64(getEnvInt("HEALTHCHECK_MAX_CONSUMER_LAG", 10000))
+
+	return &healthcheck.Check{
+		Name:           "consumer-lag",
+		Severity:       healthcheck.SeverityCritical,
+		BusinessImpact: "Растущий лаг потребителя означает, что события обрабатываются с задержкой или не обрабатываются вовсе",
+		Interval:       15 * time.Second,
+		Run: func(ctx context.Context) (string, error) {
+			lag := consumerLagByTopic()
+			for topic, topicLag := range lag {
+				if topicLag > threshold {
+					return "", fmt.Errorf("лаг топика %s (%d) превышает порог %d", topic, topicLag, threshold)
+				}
+			}
+			return fmt.Sprintf("%v", lag), nil
+		},
+	}
+}
+
+// Sink — точка записи потребленных сообщений во внешнее хранилище.
+// Write должен быть идемпотентным: в сочетании с коммитом оффсетов только
+// после успешной записи это дает семантику "примерно ровно один раз".
+type Sink interface {
+	Write(ctx context.Context, topic string, messages []kafka.Message) error
+	Close() error
+}
+
+// logSink — поведение по умолчанию: просто логирует сообщения, как раньше.
+type logSink struct{}
+
+func (logSink) Write(ctx context.Context, topic string, messages []kafka.Message) error {
+	for _, message := range messages {
+		if isMetricsTopic(topic) {
+			log.Printf("[ПОТРЕБИТЕЛЬ] Топик: %s, Смещение: %d, Сообщение: %s",
+				topic, message.Offset, string(message.Value))
+			continue
+		}
+
+		eventData, err := getCodec().Decode(topic, message.Value)
+		if err != nil {
+			log.Printf("[ПОТРЕБИТЕЛЬ] Топик: %s, Смещение: %d, Ошибка декодирования (%s): %v",
+				topic, message.Offset, getCodec().Name(), err)
+			continue
+		}
+
+		eventBytes, _ := json.Marshal(eventData)
+		log.Printf("[ПОТРЕБИТЕЛЬ] Топик: %s, Смещение: %d, Сообщение: %s",
+			topic, message.Offset, string(eventBytes))
+	}
+	return nil
+}
+
+func (logSink) Close() error { return nil }
+
+// postgresSink пишет потребленные сообщения в Postgres с upsert по (topic, partition, offset),
+// что делает повторную обработку после ребаланса/рестарта безопасной.
+type postgresSink struct {
+	db *sql.DB
+}
+
+func newPostgresSink() (*postgresSink, error) {
+	dsn := getEnv("POSTGRES_DSN", "")
+	if dsn == "" {
+		return nil, fmt.Errorf("POSTGRES_DSN не задан")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть соединение с Postgres: %w", err)
+	}
+
+	return &postgresSink{db: db}, nil
+}
+
+func (s *postgresSink) Write(ctx context.Context, topic string, messages []kafka.Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть транзакцию Postgres: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO consumed_events (topic, "partition", "offset", payload, consumed_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (topic, "partition", "offset") DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("не удалось подготовить запрос upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		if _, err := stmt.ExecContext(ctx, topic, message.Partition, message.Offset, message.Value); err != nil {
+			return fmt.Errorf("не удалось записать сообщение смещения %d: %w", message.Offset, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresSink) Close() error {
+	return s.db.Close()
+}
+
+// influxSink пишет потребленные сообщения как точки InfluxDB v2, по одной на сообщение.
+type influxSink struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPIBlocking
+}
+
+func newInfluxSink() (*influxSink, error) {
+	url := getEnv("INFLUX_URL", "")
+	if url == "" {
+		return nil, fmt.Errorf("INFLUX_URL не задан")
+	}
+
+	token := getEnv("INFLUX_TOKEN", "")
+	org := getEnv("INFLUX_ORG", "")
+	bucket := getEnv("INFLUX_BUCKET", "cinemaabyss-events")
+
+	client := influxdb2.NewClient(url, token)
+	return &influxSink{client: client, writeAPI: client.WriteAPIBlocking(org, bucket)}, nil
+}
+
+func (s *influxSink) Write(ctx context.Context, topic string, messages []kafka.Message) error {
+	for _, message := range messages {
+		// offset входит в набор тегов, а не только в поля: точка InfluxDB идентифицируется
+		// по (measurement, tags, timestamp), и продюсеры в этом файле никогда не
+		// выставляют kafka.Message.Time, так что kafka-go подставляет LogAppendTime —
+		// один и тот же момент для всех сообщений одной продюсерской пачки. Без offset
+		// в тегах такие сообщения схлопнулись бы в одну точку, и WritePoint молча
+		// затёр бы все, кроме последней.
+		point := influxdb2.NewPoint(
+			topic,
+			map[string]string{
+				"partition": strconv.Itoa(message.Partition),
+				"offset":    strconv.FormatInt(message.Offset, 10),
+			},
+			map[string]interface{}{"value": string(message.Value)},
+			message.Time,
+		)
+		if err := s.writeAPI.WritePoint(ctx, point); err != nil {
+			return fmt.Errorf("не удалось записать точку InfluxDB для смещения %d: %w", message.Offset, err)
+		}
+	}
+	return nil
+}
+
+func (s *influxSink) Close() error {
+	s.client.Close()
+	return nil
+}
+
+// s3Sink складывает пачку сообщений в один сжатый NDJSON-объект в S3/MinIO,
+// партиционированный по топику и часу — удобно для последующей загрузки в DWH.
+type s3Sink struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Sink() (*s3Sink, error) {
+	endpoint := getEnv("S3_ENDPOINT", "")
+	if endpoint == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT не задан")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(getEnv("S3_ACCESS_KEY", ""), getEnv("S3_SECRET_KEY", ""), ""),
+		Secure: getEnv("S3_USE_SSL", "true") == "true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать клиент MinIO: %w", err)
+	}
+
+	return &s3Sink{client: client, bucket: getEnv("S3_BUCKET", "cinemaabyss-events")}, nil
+}
+
+func (s *s3Sink) Write(ctx context.Context, topic string, messages []kafka.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, message := range messages {
+		gz.Write(message.Value)
+		gz.Write([]byte("\n"))
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("не удалось сжать пачку сообщений: %w", err)
+	}
+
+	// Ключ объекта выводится из самой пачки (партиция, диапазон оффсетов), а не из
+	// случайного UUID: повторная доставка той же пачки после неудачного CommitMessages
+	// или падения процесса между Write и коммитом должна перезаписать тот же объект,
+	// а не создать дубликат — это и есть идемпотентность, которой требует интерфейс Sink
+	first, last := messages[0], messages[len(messages)-1]
+	key := fmt.Sprintf("%s/dt=%s/hh=%02d/%d-%d-%d.json.gz",
+		topic, first.Time.UTC().Format("2006-01-02"), first.Time.UTC().Hour(),
+		first.Partition, first.Offset, last.Offset)
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType:     "application/x-ndjson",
+		ContentEncoding: "gzip",
+	})
+	if err != nil {
+		return fmt.Errorf("не удалось загрузить объект %s в бакет %s: %w", key, s.bucket, err)
+	}
+	return nil
+}
+
+func (s *s3Sink) Close() error { return nil }
+
+var (
+	sinks   = map[string]Sink{}
+	sinksMu sync.Mutex
+)
+
+// sinkEnvKey превращает имя топика в имя переменной окружения SINK_<TOPIC>
+func sinkEnvKey(topic string) string {
+	return "SINK_" + strings.ToUpper(strings.ReplaceAll(topic, "-", "_"))
+}
+
+// getSinkForTopic возвращает (создавая при первом обращении) sink для топика,
+// выбранный переменной окружения SINK_<TOPIC>=postgres|influx|s3|log.
+func getSinkForTopic(topic string) Sink {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	if sink, ok := sinks[topic]; ok {
+		return sink
+	}
+
+	sinkType := getEnv(sinkEnvKey(topic), "log")
+
+	var sink Sink
+	var err error
+	switch sinkType {
+	case "postgres":
+		sink, err = newPostgresSink()
+	case "influx":
+		sink, err = newInfluxSink()
+	case "s3":
+		sink, err = newS3Sink()
+	case "log":
+		sink = logSink{}
+	default:
+		err = fmt.Errorf("неизвестный тип sink %q", sinkType)
+	}
+
+	if err != nil {
+		log.Printf("Ошибка инициализации sink '%s' для топика %s: %v, используется log sink", sinkType, topic, err)
+		sink = logSink{}
+	}
+
+	sinks[topic] = sink
+	return sink
+}
+
+var (
+	topicReaders   = map[string]*kafka.Reader{}
+	topicReadersMu sync.Mutex
+)
+
+// consumerLagByTopic возвращает текущий лаг потребителя по каждому зарегистрированному топику
+func consumerLagByTopic() map[string]int64 {
+	topicReadersMu.Lock()
+	defer topicReadersMu.Unlock()
+
+	lag := make(map[string]int64, len(topicReaders))
+	for topic, reader := range topicReaders {
+		lag[topic] = reader.Stats().Lag
+	}
+	return lag
+}
+
+// consumeTopic потребляет сообщения из указанного топика Kafka, буферизует их и
+// сбрасывает пачками в Sink, коммитя оффсеты только после успешной записи
 func consumeTopic(ctx context.Context, topic string, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
 	brokers := strings.Split(kafkaBrokers, ",")
 
+	dialer, err := newKafkaDialer()
+	if err != nil {
+		log.Printf("Ошибка настройки TLS/SASL для Kafka reader'а топика %s: %v, используется соединение без аутентификации", topic, err)
+		dialer = nil
+	}
+
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  brokers,
 		Topic:    topic,
 		GroupID:  "cinemaabyss-events-consumer-group",
 		MinBytes: 10e3,
 		MaxBytes: 10e6,
+		Dialer:   dialer,
 	})
 	defer reader.Close()
 
+	topicReadersMu.Lock()
+	topicReaders[topic] = reader
+	topicReadersMu.Unlock()
+
+	sink := getSinkForTopic(topic)
+	defer sink.Close()
+
+	batchSize := getEnvInt("SINK_BATCH_SIZE", 100)
+	flushInterval := getEnvDuration("SINK_FLUSH_INTERVAL", 5*time.Second)
+
+	batch := make([]kafka.Message, 0, batchSize)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := sink.Write(context.Background(), topic, batch); err != nil {
+			log.Printf("Ошибка записи в sink для топика %s: %v, сообщения будут обработаны повторно", topic, err)
+			return
+		}
+
+		if err := reader.CommitMessages(context.Background(), batch...); err != nil {
+			log.Printf("Ошибка коммита оффсетов для топика %s: %v", topic, err)
+			return
+		}
+
+		batch = batch[:0]
+	}
+
 	log.Printf("Потребитель запущен для топика: %s", topic)
 
 	for {
-		message, err := reader.ReadMessage(ctx)
+		select {
+		case <-ctx.Done():
+			flush()
+			log.Printf("Потребитель топика %s остановлен", topic)
+			return
+		case <-ticker.C:
+			flush()
+		default:
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Second)
+		message, err := reader.FetchMessage(fetchCtx)
+		cancel()
 		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				continue
+			}
+			if errors.Is(err, context.Canceled) {
+				flush()
+				log.Printf("Потребитель топика %s остановлен", topic)
+				return
+			}
 			log.Printf("Ошибка чтения из топика %s: %v", topic, err)
-			break
+			flush()
+			return
 		}
 
-		log.Printf("[ПОТРЕБИТЕЛЬ] Топик: %s, Смещение: %d, Сообщение: %s",
-			message.Topic, message.Offset, string(message.Value))
+		batch = append(batch, message)
+		if len(batch) >= batchSize {
+			flush()
+		}
 	}
 }
@@ -1,25 +1,264 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"gopkg.in/yaml.v3"
+
+	"github.com/AlexanderMATI/architecture-pro-cinemaabyss/internal/healthcheck"
 )
 
 // Конфигурация прокси
 type ProxyConfig struct {
-	Port                  string
-	MonolithURL           *url.URL
-	MoviesServiceURL      *url.URL
-	EventsServiceURL      *url.URL
-	GradualMigration      bool
+	Port                   string
+	MonolithURL            *url.URL
+	MoviesServiceURL       *url.URL
+	EventsServiceURL       *url.URL
+	GradualMigration       bool
 	MoviesMigrationPercent int
+	RoutingRulesPath       string
+	Routing                *RoutingRules
+
+	MonolithProxy *httputil.ReverseProxy
+	MoviesProxy   *httputil.ReverseProxy
+	EventsProxy   *httputil.ReverseProxy
+
+	MonolithBreaker *CircuitBreaker
+	MoviesBreaker   *CircuitBreaker
+	EventsBreaker   *CircuitBreaker
+}
+
+// Prometheus метрики прокси
+var (
+	proxyRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_requests_total",
+		Help: "Количество запросов, проксированных на каждый upstream, по исходу",
+	}, []string{"target", "outcome"})
+
+	proxyCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_circuit_state",
+		Help: "Состояние circuit breaker'а upstream'а: 0=closed, 1=open, 2=half-open",
+	}, []string{"target"})
+)
+
+func init() {
+	prometheus.MustRegister(proxyRequestsTotal, proxyCircuitState)
+}
+
+// CircuitState — состояние circuit breaker'а
+type CircuitState int
+
+const (
+	StateClosed CircuitState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker — простой circuit breaker в стиле sony/gobreaker: closed -> open после
+// maxConsecutiveFailures подряд идущих ошибок/таймаутов, half-open пробный запрос после
+// cooldown, успех пробы закрывает breaker, неудача снова открывает.
+type CircuitBreaker struct {
+	name                   string
+	maxConsecutiveFailures int
+	cooldown               time.Duration
+
+	mu                  sync.Mutex
+	state               CircuitState
+	consecutiveFailures int
+	openedAt            time.Time
+}
+
+// NewCircuitBreaker создает CircuitBreaker для именованного upstream'а
+func NewCircuitBreaker(name string, maxConsecutiveFailures int, cooldown time.Duration) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		name:                   name,
+		maxConsecutiveFailures: maxConsecutiveFailures,
+		cooldown:               cooldown,
+	}
+	proxyCircuitState.WithLabelValues(name).Set(float64(StateClosed))
+	return cb
+}
+
+// Allow сообщает, можно ли пропустить запрос: да, если breaker закрыт, либо если он открыт,
+// но cooldown истек и можно выпустить пробный (half-open) запрос.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case StateClosed:
+		return true
+	case StateOpen:
+		if time.Since(cb.openedAt) >= cb.cooldown {
+			cb.state = StateHalfOpen
+			proxyCircuitState.WithLabelValues(cb.name).Set(float64(StateHalfOpen))
+			return true
+		}
+		return false
+	default: // StateHalfOpen
+		// Пока пробный запрос не завершился, не выпускаем новые
+		return false
+	}
+}
+
+// RecordSuccess отмечает успешный запрос: сбрасывает счетчик ошибок и закрывает breaker
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveFailures = 0
+	if cb.state != StateClosed {
+		cb.state = StateClosed
+		proxyCircuitState.WithLabelValues(cb.name).Set(float64(StateClosed))
+	}
+}
+
+// RecordFailure отмечает неудачный запрос (5xx/таймаут/ошибка соединения)
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateHalfOpen {
+		cb.trip()
+		return
+	}
+
+	cb.consecutiveFailures++
+	if cb.consecutiveFailures >= cb.maxConsecutiveFailures {
+		cb.trip()
+	}
+}
+
+// trip переводит breaker в открытое состояние (должен вызываться с удержанным mu)
+func (cb *CircuitBreaker) trip() {
+	cb.state = StateOpen
+	cb.openedAt = time.Now()
+	proxyCircuitState.WithLabelValues(cb.name).Set(float64(StateOpen))
+}
+
+// State возвращает текущее состояние breaker'а
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// RoutingRule описывает canary-правило маршрутизации для префикса URL
+type RoutingRule struct {
+	Prefix          string            `json:"prefix" yaml:"prefix"`
+	Target          string            `json:"target" yaml:"target"`
+	Percent         int               `json:"percent" yaml:"percent"`
+	StickyBy        string            `json:"sticky_by" yaml:"sticky_by"`
+	HeaderOverrides map[string]string `json:"header_overrides" yaml:"header_overrides"`
+	AllowListUsers  []string          `json:"allow_list_users" yaml:"allow_list_users"`
+}
+
+// RoutingRules хранит правила маршрутизации, загруженные из ROUTING_RULES_PATH,
+// и позволяет перечитывать их без перезапуска сервиса (см. /admin/routing).
+type RoutingRules struct {
+	mu    sync.RWMutex
+	path  string
+	rules []RoutingRule
+}
+
+// NewRoutingRules создает RoutingRules и выполняет первоначальную загрузку из path.
+// Пустой path означает, что per-route правила не используются.
+func NewRoutingRules(path string) *RoutingRules {
+	rr := &RoutingRules{path: path}
+	if path != "" {
+		if err := rr.Reload(); err != nil {
+			log.Printf("Ошибка загрузки ROUTING_RULES_PATH '%s': %v, per-route правила отключены", path, err)
+		}
+	}
+	return rr
+}
+
+// Reload перечитывает правила маршрутизации с диска
+func (rr *RoutingRules) Reload() error {
+	if rr.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(rr.path)
+	if err != nil {
+		return err
+	}
+
+	var rules []RoutingRule
+	switch strings.ToLower(filepath.Ext(rr.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &rules)
+	default:
+		err = json.Unmarshal(data, &rules)
+	}
+	if err != nil {
+		return err
+	}
+
+	rr.mu.Lock()
+	rr.rules = rules
+	rr.mu.Unlock()
+
+	log.Printf("Загружено %d правил маршрутизации из %s", len(rules), rr.path)
+	return nil
+}
+
+// Match возвращает правило с наиболее длинным совпадающим префиксом для path, если есть
+func (rr *RoutingRules) Match(path string) (RoutingRule, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	var best RoutingRule
+	found := false
+	for _, rule := range rr.rules {
+		if strings.HasPrefix(path, rule.Prefix) && (!found || len(rule.Prefix) > len(best.Prefix)) {
+			best = rule
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Snapshot возвращает копию текущих правил (для /admin/routing)
+func (rr *RoutingRules) Snapshot() []RoutingRule {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	rules := make([]RoutingRule, len(rr.rules))
+	copy(rules, rr.rules)
+	return rules
 }
 
 // getEnv возвращает значение переменной окружения или значение по умолчанию
@@ -51,9 +290,6 @@ func parseMigrationPercent(percentStr string) int {
 
 // loadConfig загружает конфигурацию из переменных окружения
 func loadConfig() *ProxyConfig {
-	// Инициализация генератора случайных чисел
-	rand.New(rand.NewSource(time.Now().UnixNano()))
-	
 	// Чтение конфигурации
 	port := getEnv("PORT", "8000")
 	monolithURL := getEnv("MONOLITH_URL", "http://localhost:8080")
@@ -61,91 +297,483 @@ func loadConfig() *ProxyConfig {
 	eventsServiceURL := getEnv("EVENTS_SERVICE_URL", "http://localhost:8082")
 	gradualMigration := getEnv("GRADUAL_MIGRATION", "false") == "true"
 	migrationPercentStr := getEnv("MOVIES_MIGRATION_PERCENT", "0")
-	
+
 	// Парсинг URL
 	monoURL := parseURL(monolithURL, "MONOLITH_URL")
 	movURL := parseURL(moviesServiceURL, "MOVIES_SERVICE_URL")
 	evtURL := parseURL(eventsServiceURL, "EVENTS_SERVICE_URL")
-	
+
 	// Парсинг процента миграции
 	migrationPercent := parseMigrationPercent(migrationPercentStr)
-	
+
+	routingRulesPath := getEnv("ROUTING_RULES_PATH", "")
+
+	breakerMaxFailures := getEnvInt("CIRCUIT_BREAKER_MAX_FAILURES", 5)
+	breakerCooldown := getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second)
+
+	monolithBreaker := NewCircuitBreaker("monolith", breakerMaxFailures, breakerCooldown)
+	moviesBreaker := NewCircuitBreaker("movies-service", breakerMaxFailures, breakerCooldown)
+	eventsBreaker := NewCircuitBreaker("events-service", breakerMaxFailures, breakerCooldown)
+
+	tokenProvider := newOAuthTokenProvider()
+
+	monolithProxy := buildProxy("monolith", monoURL, monolithBreaker, nil, nil)
+	moviesProxy := buildProxy("movies-service", movURL, moviesBreaker, monolithProxy, tokenProvider)
+	eventsProxy := buildProxy("events-service", evtURL, eventsBreaker, nil, tokenProvider)
+
 	return &ProxyConfig{
-		Port:                  port,
-		MonolithURL:           monoURL,
-		MoviesServiceURL:      movURL,
-		EventsServiceURL:      evtURL,
-		GradualMigration:      gradualMigration,
+		Port:                   port,
+		MonolithURL:            monoURL,
+		MoviesServiceURL:       movURL,
+		EventsServiceURL:       evtURL,
+		GradualMigration:       gradualMigration,
 		MoviesMigrationPercent: migrationPercent,
+		RoutingRulesPath:       routingRulesPath,
+		Routing:                NewRoutingRules(routingRulesPath),
+		MonolithProxy:          monolithProxy,
+		MoviesProxy:            moviesProxy,
+		EventsProxy:            eventsProxy,
+		MonolithBreaker:        monolithBreaker,
+		MoviesBreaker:          moviesBreaker,
+		EventsBreaker:          eventsBreaker,
+	}
+}
+
+// getEnvInt возвращает целочисленное значение переменной окружения или значение по умолчанию
+func getEnvInt(key string, fallback int) int {
+	raw := getEnv(key, "")
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Некорректное значение %s '%s', используется %d", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration возвращает значение переменной окружения как time.Duration или значение по умолчанию
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	raw := getEnv(key, "")
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Некорректное значение %s '%s', используется %s", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// proxyByName возвращает закэшированный ReverseProxy по имени upstream'а
+func (config *ProxyConfig) proxyByName(name string) *httputil.ReverseProxy {
+	switch name {
+	case "movies-service":
+		return config.MoviesProxy
+	case "events-service":
+		return config.EventsProxy
+	default:
+		return config.MonolithProxy
+	}
+}
+
+// newUpstreamTransport создает http.Transport с ограниченным пулом соединений и таймаутами
+// дозвона, общий для всех upstream'ов прокси
+func newUpstreamTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConnsPerHost: 20,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+}
+
+// errCircuitOpen возвращается ErrorHandler'ом, когда breaker upstream'а разомкнут
+var errCircuitOpen = errors.New("circuit breaker открыт")
+
+// oauthTokenProvider минтит и кэширует service-to-service токен по client credentials flow
+// для проставления на запросы к movies-service/events-service под отдельным заголовком,
+// не затрагивая Authorization, которым пользователь передает свою identity.
+type oauthTokenProvider struct {
+	source oauth2.TokenSource
+}
+
+// newOAuthTokenProvider создает провайдер токена из OAUTH_TOKEN_URL/OAUTH_CLIENT_ID/OAUTH_CLIENT_SECRET/OAUTH_SCOPES.
+// Если OAUTH_TOKEN_URL не задан, OAuth отключен и возвращается nil.
+func newOAuthTokenProvider() *oauthTokenProvider {
+	tokenURL := getEnv("OAUTH_TOKEN_URL", "")
+	if tokenURL == "" {
+		return nil
+	}
+
+	cfg := clientcredentials.Config{
+		ClientID:     getEnv("OAUTH_CLIENT_ID", ""),
+		ClientSecret: getEnv("OAUTH_CLIENT_SECRET", ""),
+		TokenURL:     tokenURL,
+		Scopes:       strings.Split(getEnv("OAUTH_SCOPES", ""), ","),
+	}
+
+	// cfg.TokenSource кэширует токен и обновляет его сам по мере истечения срока действия
+	return &oauthTokenProvider{source: cfg.TokenSource(context.Background())}
+}
+
+// Inject проставляет service-to-service Bearer токен в X-Service-Authorization. Заголовок
+// Authorization не трогаем: в нем приходит Bearer-токен конечного пользователя, и downstream
+// (scope-проверки, identityHeaders в events-service) должен видеть именно его, а не service-токен.
+func (p *oauthTokenProvider) Inject(req *http.Request, upstream string) {
+	token, err := p.source.Token()
+	if err != nil {
+		log.Printf("Ошибка получения OAuth токена для %s: %v", upstream, err)
+		return
+	}
+	req.Header.Set("X-Service-Authorization", "Bearer "+token.AccessToken)
+}
+
+// buildProxy создает ReverseProxy для targetURL, обернутый circuit breaker'ом на транспорте
+// и, при передаче fallback, прозрачно перенаправляющий на него при разомкнутом breaker'е или 5xx/ошибке соединения.
+// tokenProvider, если не nil, проставляет service-to-service Bearer токен на каждый запрос.
+func buildProxy(name string, targetURL *url.URL, breaker *CircuitBreaker, fallback *httputil.ReverseProxy, tokenProvider *oauthTokenProvider) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	transport := newUpstreamTransport()
+
+	proxy.Transport = breakerRoundTripper{name: name, breaker: breaker, next: transport}
+
+	baseDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		baseDirector(req)
+		// Буферизуем тело до того, как Transport его вычитает, иначе при откате
+		// на монолит после 5xx/ошибки мутирующий запрос уйдет с пустым телом
+		if fallback != nil {
+			bufferRequestBodyForFallback(req)
+		}
+		if tokenProvider != nil {
+			tokenProvider.Inject(req, name)
+		}
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			breaker.RecordFailure()
+			proxyRequestsTotal.WithLabelValues(name, "5xx").Inc()
+			return fmt.Errorf("upstream %s вернул статус %d", name, resp.StatusCode)
+		}
+		breaker.RecordSuccess()
+		proxyRequestsTotal.WithLabelValues(name, "success").Inc()
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		outcome := "error"
+		if errors.Is(err, errCircuitOpen) {
+			outcome = "circuit_open"
+		}
+		proxyRequestsTotal.WithLabelValues(name, outcome).Inc()
+		log.Printf("Ошибка проксирования к %s: %v", name, err)
+
+		if fallback != nil {
+			// Тело уже было прочитано и отправлено неудавшемуся upstream'у — перечитываем
+			// буферизованную в Director'е копию, чтобы монолит получил тот же payload
+			if r.GetBody != nil {
+				if body, bodyErr := r.GetBody(); bodyErr == nil {
+					r.Body = body
+				}
+			}
+			log.Printf("Откат на монолит после ошибки upstream'а %s", name)
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		http.Error(w, "Сервис временно недоступен", http.StatusBadGateway)
+	}
+
+	return proxy
+}
+
+// bufferRequestBodyForFallback читает тело запроса в память и проставляет GetBody, чтобы
+// ErrorHandler мог перечитать то же тело при откате на монолит после того, как Transport
+// уже вычитал и отправил его неудавшемуся upstream'у
+func bufferRequestBodyForFallback(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		log.Printf("Не удалось буферизовать тело запроса для отката на монолит: %v", err)
+		req.Body = http.NoBody
+		return
 	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+}
+
+// breakerRoundTripper оборачивает http.RoundTripper проверкой circuit breaker'а
+type breakerRoundTripper struct {
+	name    string
+	breaker *CircuitBreaker
+	next    http.RoundTripper
+}
+
+func (rt breakerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.breaker.Allow() {
+		return nil, errCircuitOpen
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		rt.breaker.RecordFailure()
+		return nil, err
+	}
+
+	return resp, nil
 }
 
-// createReverseProxy создает обратный прокси для указанного URL
-func createReverseProxy(targetURL *url.URL) *httputil.ReverseProxy {
-	return httputil.NewSingleHostReverseProxy(targetURL)
+// stableBucket хэширует идентификатор пользователя в стабильный бакет [0, 99] через FNV-1a,
+// так что один и тот же пользователь всегда попадает в одну и ту же группу канареечного релиза.
+func stableBucket(identifier string) int {
+	h := fnv.New32a()
+	h.Write([]byte(identifier))
+	return int(h.Sum32() % 100)
 }
 
-// shouldRouteToMovies определяет, нужно ли маршрутизировать запрос к сервису фильмов
-func shouldRouteToMovies(config *ProxyConfig) bool {
+// jwtSubClaim извлекает claim "sub" из JWT в заголовке Authorization: Bearer без проверки подписи —
+// подпись для маршрутизации не важна, решение о доступе принимает вышестоящий сервис.
+func jwtSubClaim(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Sub == "" {
+		return "", false
+	}
+
+	return claims.Sub, true
+}
+
+// clientIP возвращает IP клиента без порта
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if idx := strings.LastIndex(ip, ":"); idx != -1 {
+		ip = ip[:idx]
+	}
+	return ip
+}
+
+// userIdentifier определяет идентификатор пользователя для sticky-маршрутизации. Сначала
+// пробуется источник, выбранный stickyBy ("header"/"user_id"/"" — X-User-Id, "jwt_sub",
+// "session_cookie", "ip" — IP клиента сразу); если он пуст, действует порядок по умолчанию:
+// заголовок X-User-Id, claim "sub" из JWT, cookie session_id, IP клиента.
+func userIdentifier(r *http.Request, stickyBy string) string {
+	switch stickyBy {
+	case "header", "user_id", "":
+		if userID := r.Header.Get("X-User-Id"); userID != "" {
+			return userID
+		}
+	case "jwt_sub":
+		if sub, ok := jwtSubClaim(r); ok {
+			return sub
+		}
+	case "session_cookie":
+		if cookie, err := r.Cookie("session_id"); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	case "ip":
+		return clientIP(r)
+	}
+
+	if userID := r.Header.Get("X-User-Id"); userID != "" {
+		return userID
+	}
+	if sub, ok := jwtSubClaim(r); ok {
+		return sub
+	}
+	if cookie, err := r.Cookie("session_id"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return clientIP(r)
+}
+
+// shouldRouteToMovies определяет, нужно ли маршрутизировать запрос к сервису фильмов,
+// на основе стабильного бакета пользователя вместо броска монеты на каждый запрос.
+func shouldRouteToMovies(config *ProxyConfig, r *http.Request) bool {
 	if !config.GradualMigration {
 		return false
 	}
-	
-	// Генерация случайного числа от 0 до 99
-	randomValue := rand.Intn(100)
-	return randomValue < config.MoviesMigrationPercent
+
+	bucket := stableBucket(userIdentifier(r, ""))
+	return bucket < config.MoviesMigrationPercent
+}
+
+// isAllowListedUser проверяет, входит ли идентификатор пользователя в allow_list_users правила
+func isAllowListedUser(identifier string, allowList []string) bool {
+	for _, allowed := range allowList {
+		if allowed == identifier {
+			return true
+		}
+	}
+	return false
 }
 
-// routeRequest определяет куда маршрутизировать запрос
-func routeRequest(config *ProxyConfig, path string) (*httputil.ReverseProxy, string) {
+// routeRequest определяет куда маршрутизировать запрос. Per-route правила из ROUTING_RULES_PATH
+// (если заданы и совпал префикс) имеют приоритет над legacy GRADUAL_MIGRATION/MOVIES_MIGRATION_PERCENT.
+func routeRequest(config *ProxyConfig, r *http.Request) (*httputil.ReverseProxy, string) {
+	path := r.URL.Path
+
+	if config.Routing != nil {
+		if rule, ok := config.Routing.Match(path); ok {
+			return routeByRule(config, r, rule)
+		}
+	}
+
 	switch {
 	case strings.HasPrefix(path, "/api/movies"):
-		if shouldRouteToMovies(config) {
-			return createReverseProxy(config.MoviesServiceURL), "movies-service"
+		if shouldRouteToMovies(config, r) {
+			return config.MoviesProxy, "movies-service"
 		}
-		return createReverseProxy(config.MonolithURL), "monolith"
-		
+		return config.MonolithProxy, "monolith"
+
 	case strings.HasPrefix(path, "/api/events"):
-		return createReverseProxy(config.EventsServiceURL), "events-service"
-		
+		return config.EventsProxy, "events-service"
+
 	default:
-		return createReverseProxy(config.MonolithURL), "monolith"
+		return config.MonolithProxy, "monolith"
 	}
 }
 
+// routeByRule применяет одно per-route правило: allow-list пользователей обходит percent,
+// иначе решение принимается по стабильному бакету идентификатора пользователя.
+func routeByRule(config *ProxyConfig, r *http.Request, rule RoutingRule) (*httputil.ReverseProxy, string) {
+	identifier := userIdentifier(r, rule.StickyBy)
+
+	routeToTarget := isAllowListedUser(identifier, rule.AllowListUsers) || stableBucket(identifier) < rule.Percent
+
+	if !routeToTarget {
+		return config.MonolithProxy, "monolith"
+	}
+
+	for header, value := range rule.HeaderOverrides {
+		r.Header.Set(header, value)
+	}
+
+	targetName := rule.Target
+	if targetName != "movies-service" && targetName != "events-service" {
+		targetName = "monolith"
+	}
+	return config.proxyByName(targetName), targetName
+}
+
 // mainHandler обрабатывает входящие HTTP запросы
 func mainHandler(config *ProxyConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Логирование входящего запроса
 		log.Printf("Входящий запрос: %s %s", r.Method, r.URL.Path)
-		
+
 		// Определение целевого сервиса и создание прокси
-		proxy, targetService := routeRequest(config, r.URL.Path)
-		
+		proxy, targetService := routeRequest(config, r)
+
 		// Логирование маршрутизации
 		log.Printf("Маршрутизация к %s", targetService)
-		
+
+		// Заголовок для наблюдаемости canary-маршрутизации
+		w.Header().Set("X-Canary-Target", targetService)
+
 		// Проксирование запроса
 		proxy.ServeHTTP(w, r)
 	}
 }
 
-// healthHandler обработчик проверки здоровья
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	response := map[string]string{
-		"status":   "healthy",
-		"service":  "strangler-fig-proxy",
-		"datetime": time.Now().Format(time.RFC3339),
+// routingAdminHandler отдает текущие правила маршрутизации (GET) или перечитывает их
+// с диска без перезапуска сервиса (POST)
+func routingAdminHandler(config *ProxyConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.Routing == nil {
+			http.Error(w, "ROUTING_RULES_PATH не настроен", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := config.Routing.Reload(); err != nil {
+				http.Error(w, "Ошибка перезагрузки правил: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":  config.RoutingRulesPath,
+			"rules": config.Routing.Snapshot(),
+		})
+	}
+}
+
+// newUpstreamHeadCheck проверяет доступность upstream'а HTTP HEAD-запросом на healthPath
+// и добавляет состояние circuit breaker'а к результату — разомкнутый breaker тоже считается сбоем.
+// severity должна быть SeverityWarning для upstream'а, с которого прокси умеет откатываться
+// на монолит (movies-service) — иначе /__gtg будет 503'ить при live canary на 0%, хотя
+// монолит прекрасно обслуживает весь трафик.
+func newUpstreamHeadCheck(name string, target *url.URL, breaker *CircuitBreaker, timeout time.Duration, severity int, healthPath string) *healthcheck.Check {
+	client := &http.Client{Timeout: timeout}
+
+	return &healthcheck.Check{
+		Name:           name,
+		Severity:       severity,
+		BusinessImpact: fmt.Sprintf("Трафик на %s будет отбрасываться или уходить в фолбэк, пока upstream недоступен", name),
+		Interval:       getEnvDuration("HEALTHCHECK_UPSTREAM_INTERVAL", 10*time.Second),
+		Run: func(ctx context.Context) (string, error) {
+			healthURL := *target
+			healthURL.Path = strings.TrimRight(healthURL.Path, "/") + healthPath
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, healthURL.String(), nil)
+			if err != nil {
+				return "", fmt.Errorf("не удалось собрать запрос: %w", err)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return "", fmt.Errorf("HEAD %s: %w", healthURL.String(), err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode >= 500 {
+				return "", fmt.Errorf("HEAD %s вернул %d", healthURL.String(), resp.StatusCode)
+			}
+
+			breakerState := breaker.State()
+			if breakerState == StateOpen {
+				return "", fmt.Errorf("circuit breaker '%s' разомкнут", name)
+			}
+
+			return fmt.Sprintf("HEAD %s вернул %d, breaker=%s", healthURL.String(), resp.StatusCode, breakerState), nil
+		},
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	
-	// В реальном приложении здесь был бы json.NewEncoder
-	jsonResponse := `{"status":"healthy","service":"strangler-fig-proxy","datetime":"` + 
-		time.Now().Format(time.RFC3339) + `"}`
-	
-	w.Write([]byte(jsonResponse))
 }
 
 // logConfig выводит конфигурацию при запуске
@@ -161,23 +789,37 @@ func logConfig(config *ProxyConfig) {
 func main() {
 	// Загрузка конфигурации
 	config := loadConfig()
-	
-	// Создание прокси для каждого сервиса
-	// (в реальном коде они создаются в routeRequest, но можно кэшировать)
-	
+
+	// Фоновые проверки зависимостей для /__health и /__gtg: активный HEAD health-эндпоинта
+	// каждого upstream'а плюс состояние его circuit breaker'а
+	upstreamTimeout := getEnvDuration("HEALTHCHECK_UPSTREAM_TIMEOUT", 3*time.Second)
+	healthRegistry := healthcheck.NewRegistry([]*healthcheck.Check{
+		// Монолит — единственный upstream без фолбэка, его недоступность реально блокирует трафик
+		newUpstreamHeadCheck("monolith", config.MonolithURL, config.MonolithBreaker, upstreamTimeout, healthcheck.SeverityCritical, "/health"),
+		// movies-service деградирует на фолбэк монолита — не должен валить /__gtg
+		newUpstreamHeadCheck("movies-service", config.MoviesServiceURL, config.MoviesBreaker, upstreamTimeout, healthcheck.SeverityWarning, "/health"),
+		// events-service отката на монолит не имеет (при его сбое /api/events получает 502), его
+		// проверка тоже регистрируется как SeverityWarning. /health он не отдает — проверяем его /__gtg
+		newUpstreamHeadCheck("events-service", config.EventsServiceURL, config.EventsBreaker, upstreamTimeout, healthcheck.SeverityWarning, "/__gtg"),
+	})
+	defer healthRegistry.Stop()
+
 	// Настройка HTTP обработчиков
 	http.HandleFunc("/", mainHandler(config))
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/api/health", healthHandler)
-	
+	http.HandleFunc("/admin/routing", routingAdminHandler(config))
+	http.HandleFunc("/__health", healthRegistry.HealthHandler("strangler-fig-proxy", nil))
+	http.HandleFunc("/__gtg", healthRegistry.GTGHandler())
+	http.HandleFunc("/__build-info", healthcheck.BuildInfoHandler("strangler-fig-proxy"))
+	http.Handle("/metrics", promhttp.Handler())
+
 	// Логирование конфигурации
 	logConfig(config)
-	
+
 	// Запуск HTTP сервера
 	serverAddr := ":" + config.Port
 	log.Printf("Запуск сервера на %s", serverAddr)
-	
+
 	if err := http.ListenAndServe(serverAddr, nil); err != nil {
 		log.Fatalf("Ошибка запуска сервера: %v", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStableBucketIsDeterministicAndBounded(t *testing.T) {
+	for _, id := range []string{"user-1", "user-2", ""} {
+		first := stableBucket(id)
+		second := stableBucket(id)
+		if first != second {
+			t.Fatalf("stableBucket(%q) not deterministic: %d != %d", id, first, second)
+		}
+		if first < 0 || first > 99 {
+			t.Fatalf("stableBucket(%q) = %d, want [0, 99]", id, first)
+		}
+	}
+}
+
+func TestJWTSubClaimExtractsSubWithoutVerifyingSignature(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-42"}`))
+	token := "eyJhbGciOiJub25lIn0." + payload + ".garbage-signature"
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	sub, ok := jwtSubClaim(r)
+	if !ok || sub != "user-42" {
+		t.Fatalf("jwtSubClaim() = (%q, %v), want (\"user-42\", true)", sub, ok)
+	}
+}
+
+func TestJWTSubClaimMissingAuthHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := jwtSubClaim(r); ok {
+		t.Fatalf("jwtSubClaim() returned ok=true without an Authorization header")
+	}
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailuresAndRecoversThroughHalfOpen(t *testing.T) {
+	cb := NewCircuitBreaker("test", 3, 10*time.Millisecond)
+
+	if !cb.Allow() {
+		t.Fatalf("closed breaker should allow requests")
+	}
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	if cb.State() != StateClosed {
+		t.Fatalf("breaker tripped before reaching maxConsecutiveFailures")
+	}
+
+	cb.RecordFailure()
+	if cb.State() != StateOpen {
+		t.Fatalf("breaker did not trip after maxConsecutiveFailures consecutive failures")
+	}
+	if cb.Allow() {
+		t.Fatalf("open breaker should not allow requests before cooldown elapses")
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatalf("breaker should allow a half-open probe once cooldown elapses")
+	}
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("breaker should be half-open after the probe is allowed, got %s", cb.State())
+	}
+
+	cb.RecordSuccess()
+	if cb.State() != StateClosed {
+		t.Fatalf("breaker should close after a successful half-open probe")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := NewCircuitBreaker("test", 1, 5*time.Millisecond)
+
+	cb.RecordFailure()
+	if cb.State() != StateOpen {
+		t.Fatalf("breaker should trip after a single failure when maxConsecutiveFailures=1")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatalf("breaker should allow a half-open probe once cooldown elapses")
+	}
+
+	cb.RecordFailure()
+	if cb.State() != StateOpen {
+		t.Fatalf("a failed half-open probe should reopen the breaker")
+	}
+}
+
+func TestRouteByRuleSendsAllowListedUserToTargetRegardlessOfPercent(t *testing.T) {
+	config := newTestProxyConfig()
+	rule := RoutingRule{Prefix: "/api/movies", Target: "movies-service", Percent: 0, AllowListUsers: []string{"user-1"}}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/movies/recommendations", nil)
+	r.Header.Set("X-User-Id", "user-1")
+
+	proxy, target := routeByRule(config, r, rule)
+	if target != "movies-service" || proxy != config.MoviesProxy {
+		t.Fatalf("allow-listed user should route to movies-service, got %s", target)
+	}
+}
+
+func TestRouteByRuleSendsMajorityToMonolithAtZeroPercent(t *testing.T) {
+	config := newTestProxyConfig()
+	rule := RoutingRule{Prefix: "/api/movies", Target: "movies-service", Percent: 0}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/movies/recommendations", nil)
+	r.Header.Set("X-User-Id", "some-user-not-allow-listed")
+
+	proxy, target := routeByRule(config, r, rule)
+	if target != "monolith" || proxy != config.MonolithProxy {
+		t.Fatalf("0%% rule should route non-allow-listed users to monolith, got %s", target)
+	}
+}
+
+func TestRouteByRuleUnknownTargetNormalizesToMonolithName(t *testing.T) {
+	config := newTestProxyConfig()
+	rule := RoutingRule{Prefix: "/api/weird", Target: "unknown-service", Percent: 100}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/weird", nil)
+	r.Header.Set("X-User-Id", "user-1")
+
+	proxy, target := routeByRule(config, r, rule)
+	if target != "monolith" || proxy != config.MonolithProxy {
+		t.Fatalf("unrecognized rule target should normalize to monolith, got %s", target)
+	}
+}
+
+func newTestProxyConfig() *ProxyConfig {
+	monolithURL, _ := url.Parse("http://monolith.local")
+	moviesURL, _ := url.Parse("http://movies.local")
+
+	return &ProxyConfig{
+		MonolithProxy: httputil.NewSingleHostReverseProxy(monolithURL),
+		MoviesProxy:   httputil.NewSingleHostReverseProxy(moviesURL),
+		EventsProxy:   httputil.NewSingleHostReverseProxy(monolithURL),
+	}
+}